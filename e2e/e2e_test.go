@@ -28,8 +28,8 @@ func TestCapcityCheck(t *testing.T) {
 		require.NoError(t, err)
 		for _, node := range nodeList.Items {
 			t.Log("checking that node is not evicted", node.Name)
-			// TODO: There should be a better way to check that eviction is due to node ttl
-			return node.Spec.Unschedulable
+			_, ok := node.Annotations["xkf.xenit.io/node-ttl-eviction-started"]
+			return ok
 		}
 		return false
 	}, 1*time.Minute, 5*time.Second)
@@ -61,11 +61,10 @@ func TestTTLEviction(t *testing.T) {
 		require.Eventually(t, func() bool {
 			getNode, err := client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
 			require.NoError(t, err)
-			// TODO: There should be a better way to check that eviction is due to node ttl
-			if !getNode.Spec.Unschedulable {
+			if _, ok := getNode.Annotations["xkf.xenit.io/node-ttl-eviction-started"]; !ok {
 				return false
 			}
-			return true
+			return getNode.Spec.Unschedulable
 		}, 2*time.Minute, 1*time.Second, "node should be evicted due to TTL")
 		t.Log("node has been marked unschedulable by node ttl", node.Name)
 
@@ -0,0 +1,102 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "xkf.xenit.io"
+	Version  = "v1alpha1"
+	Kind     = "NodeTTLPolicy"
+	Resource = "nodettlpolicies"
+)
+
+// NodeTTLPolicyResource is the resource of the cluster scoped NodeTTLPolicy.
+var NodeTTLPolicyResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// NodeTTLPolicy sets the TTL and eviction behavior of the nodes matched by its node selector.
+type NodeTTLPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeTTLPolicySpec   `json:"spec"`
+	Status NodeTTLPolicyStatus `json:"status,omitempty"`
+}
+
+type NodeTTLPolicySpec struct {
+	// NodeSelector selects the nodes the policy applies to, an empty selector matches all nodes.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// TTL is the maximum lifetime of the nodes, optionally followed by a jitter like "7d~12h".
+	TTL string `json:"ttl"`
+	// Jitter spreads out the expiry of the nodes, it overrides a jitter set in the TTL.
+	Jitter string `json:"jitter,omitempty"`
+	// MaintenanceWindows restricts when evictions of the nodes may start, overriding the global maintenance windows.
+	MaintenanceWindows []string `json:"maintenanceWindows,omitempty"`
+	// MaxConcurrentEvictions limits how many of the nodes are evicted at the same time, zero meaning no limit.
+	MaxConcurrentEvictions int `json:"maxConcurrentEvictions,omitempty"`
+	// Drain overrides the global drain options for the nodes.
+	Drain DrainSpec `json:"drain,omitempty"`
+	// Priority decides which policy applies to a node matched by multiple policies, the highest priority wins.
+	Priority int `json:"priority,omitempty"`
+}
+
+type DrainSpec struct {
+	// Timeout is how long a drain may take before the timeout policy is applied.
+	Timeout string `json:"timeout,omitempty"`
+	// TimeoutPolicy is either Uncordon or DeletePods.
+	TimeoutPolicy string `json:"timeoutPolicy,omitempty"`
+	// Backoff is how long to wait before evicting a node again after it has been uncordoned due to a drain timeout.
+	Backoff string `json:"backoff,omitempty"`
+	// Force allows Pods which are not managed by a controller to be deleted.
+	Force *bool `json:"force,omitempty"`
+	// GracePeriodSeconds overrides the termination grace period of Pods, a negative value uses the grace period of the Pod.
+	GracePeriodSeconds *int `json:"gracePeriodSeconds,omitempty"`
+	// DeleteEmptyDirData allows Pods using emptyDir volumes to be deleted.
+	DeleteEmptyDirData *bool `json:"deleteEmptyDirData,omitempty"`
+	// PodSelector is a label selector limiting which Pods are drained.
+	PodSelector *string `json:"podSelector,omitempty"`
+	// SkipWaitForDeleteTimeoutSeconds skips waiting for Pods which have been deleted for longer than the timeout.
+	SkipWaitForDeleteTimeoutSeconds *int `json:"skipWaitForDeleteTimeoutSeconds,omitempty"`
+	// DisableEviction deletes Pods instead of evicting them, bypassing Pod Disruption Budgets.
+	DisableEviction *bool `json:"disableEviction,omitempty"`
+}
+
+type NodeTTLPolicyStatus struct {
+	// ObservedGeneration is the generation of the spec which the status is based on.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// MatchedNodes is the number of nodes the policy applies to.
+	MatchedNodes int `json:"matchedNodes"`
+	// NextExpiry is the earliest time at which one of the nodes will expire.
+	NextExpiry *metav1.Time `json:"nextExpiry,omitempty"`
+	// LastEviction is the last completed eviction of one of the nodes.
+	LastEviction *EvictionStatus `json:"lastEviction,omitempty"`
+}
+
+type EvictionStatus struct {
+	Node string      `json:"node"`
+	Time metav1.Time `json:"time"`
+}
+
+// FromUnstructured converts an unstructured object to a NodeTTLPolicy.
+func FromUnstructured(obj *unstructured.Unstructured) (*NodeTTLPolicy, error) {
+	policy := &NodeTTLPolicy{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// StatusToUnstructured returns a copy of the unstructured object with the status replaced.
+func StatusToUnstructured(obj *unstructured.Unstructured, status NodeTTLPolicyStatus) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return nil, err
+	}
+	updated := obj.DeepCopy()
+	updated.Object["status"] = content
+	return updated, nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/xenitab/node-ttl/internal/duration"
+)
+
+// Config is the content of the configuration file.
+type Config struct {
+	// TTLRules set the default TTL of nodes which do not have a TTL label. The first matching rule is used.
+	TTLRules []TTLRuleSpec `yaml:"ttlRules"`
+	// Drain sets the drain options which are not set explicitly with flags, options which are not set keep the flag value.
+	Drain DrainSpec `yaml:"drain"`
+}
+
+// DrainSpec configures how nodes are drained.
+type DrainSpec struct {
+	// Force allows Pods which are not managed by a controller to be deleted.
+	Force *bool `yaml:"force"`
+	// GracePeriodSeconds overrides the termination grace period of Pods, a negative value uses the grace period of the Pod.
+	GracePeriodSeconds *int `yaml:"gracePeriodSeconds"`
+	// DeleteEmptyDirData allows Pods using emptyDir volumes to be deleted.
+	DeleteEmptyDirData *bool `yaml:"deleteEmptyDirData"`
+	// Timeout is how long a drain may take before the drain timeout policy is applied.
+	Timeout *string `yaml:"timeout"`
+	// PodSelector is a label selector limiting which Pods are drained.
+	PodSelector *string `yaml:"podSelector"`
+	// SkipWaitForDeleteTimeoutSeconds skips waiting for Pods which have been deleted for longer than the timeout.
+	SkipWaitForDeleteTimeoutSeconds *int `yaml:"skipWaitForDeleteTimeoutSeconds"`
+	// DisableEviction deletes Pods instead of evicting them, bypassing Pod Disruption Budgets.
+	DisableEviction *bool `yaml:"disableEviction"`
+}
+
+// TTLRuleSpec maps nodes matching a label selector to a default TTL.
+type TTLRuleSpec struct {
+	// Selector is a label selector like "kubernetes.azure.com/agentpool in (system,user)".
+	Selector string `yaml:"selector"`
+	// TTL is a duration which may be followed by a jitter like "7d~12h".
+	TTL string `yaml:"ttl"`
+}
+
+// TTLRule is a parsed TTL rule.
+type TTLRule struct {
+	Selector labels.Selector
+	TTL      time.Duration
+	Jitter   time.Duration
+}
+
+// Load reads and parses the configuration file at the path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	return Parse(b)
+}
+
+// Parse parses the configuration and validates it.
+func Parse(b []byte) (*Config, error) {
+	cfg := &Config{}
+	err := yaml.UnmarshalWithOptions(b, cfg, yaml.Strict())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+	_, err = cfg.ParseTTLRules()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Drain.validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ParseTimeout returns the drain timeout or nil if it is not set.
+func (d DrainSpec) ParseTimeout() (*time.Duration, error) {
+	if d.Timeout == nil {
+		return nil, nil
+	}
+	timeout, err := duration.Parse(*d.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drain timeout: %w", err)
+	}
+	return &timeout, nil
+}
+
+func (d DrainSpec) validate() error {
+	_, err := d.ParseTimeout()
+	if err != nil {
+		return err
+	}
+	if d.SkipWaitForDeleteTimeoutSeconds != nil && *d.SkipWaitForDeleteTimeoutSeconds < 0 {
+		return fmt.Errorf("drain skip wait for delete timeout cannot be negative")
+	}
+	if d.PodSelector != nil {
+		_, err := labels.Parse(*d.PodSelector)
+		if err != nil {
+			return fmt.Errorf("invalid drain pod selector: %w", err)
+		}
+	}
+	return nil
+}
+
+// ParseTTLRules parses the selectors and durations of all TTL rules.
+func (c *Config) ParseTTLRules() ([]TTLRule, error) {
+	rules := []TTLRule{}
+	for i, spec := range c.TTLRules {
+		if spec.Selector == "" {
+			return nil, fmt.Errorf("ttl rule %d: selector cannot be empty", i)
+		}
+		selector, err := labels.Parse(spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("ttl rule %d: invalid selector: %w", i, err)
+		}
+		ttl, jitter, err := duration.ParseWithJitter(spec.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("ttl rule %d: invalid ttl: %w", i, err)
+		}
+		rules = append(rules, TTLRule{Selector: selector, TTL: ttl, Jitter: jitter})
+	}
+	return rules, nil
+}
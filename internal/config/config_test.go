@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+ttlRules:
+  - selector: kubernetes.azure.com/agentpool=system
+    ttl: 7d~12h
+  - selector: eks.amazonaws.com/nodegroup in (blue,green)
+    ttl: 24h
+`))
+	require.NoError(t, err)
+	rules, err := cfg.ParseTTLRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, 7*24*time.Hour, rules[0].TTL)
+	require.Equal(t, 12*time.Hour, rules[0].Jitter)
+	require.True(t, rules[0].Selector.Matches(labels.Set{"kubernetes.azure.com/agentpool": "system"}))
+	require.True(t, rules[1].Selector.Matches(labels.Set{"eks.amazonaws.com/nodegroup": "green"}))
+	require.False(t, rules[1].Selector.Matches(labels.Set{"eks.amazonaws.com/nodegroup": "red"}))
+}
+
+func TestParseDrain(t *testing.T) {
+	cfg, err := Parse([]byte(`
+drain:
+  force: false
+  deleteEmptyDirData: false
+  timeout: 2h
+  podSelector: app!=critical
+`))
+	require.NoError(t, err)
+	require.False(t, *cfg.Drain.Force)
+	require.False(t, *cfg.Drain.DeleteEmptyDirData)
+	require.Equal(t, "app!=critical", *cfg.Drain.PodSelector)
+	require.Nil(t, cfg.Drain.GracePeriodSeconds)
+	require.Nil(t, cfg.Drain.DisableEviction)
+	timeout, err := cfg.Drain.ParseTimeout()
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, *timeout)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":             "foo: bar",
+		"empty selector":            "ttlRules: [{ttl: 24h}]",
+		"invalid selector":          "ttlRules: [{selector: '=a', ttl: 24h}]",
+		"invalid ttl":               "ttlRules: [{selector: a=b, ttl: forever}]",
+		"invalid drain":             "drain: {timeout: forever}",
+		"invalid selector in drain": "drain: {podSelector: '=a'}",
+		"negative skip wait":        "drain: {skipWaitForDeleteTimeoutSeconds: -1}",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(content))
+			require.Error(t, err)
+		})
+	}
+}
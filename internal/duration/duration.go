@@ -0,0 +1,110 @@
+package duration
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+
+	jitterSeparator = "~"
+)
+
+// Parse parses a duration string like time.ParseDuration with the addition of the units "d" for days and "w" for
+// weeks. Units can be combined like "1w2d12h". A day is always 24 hours, daylight saving time is not considered.
+func Parse(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.New("duration cannot be empty")
+	}
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		number, unit, next, err := nextComponent(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		var d time.Duration
+		switch unit {
+		case "d", "w":
+			factor := day
+			if unit == "w" {
+				factor = week
+			}
+			f, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+			}
+			if f*float64(factor) > math.MaxInt64 {
+				return 0, fmt.Errorf("invalid duration %q: overflow", value)
+			}
+			d = time.Duration(f * float64(factor))
+		default:
+			d, err = time.ParseDuration(number + unit)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+			}
+		}
+		if total > math.MaxInt64-d {
+			return 0, fmt.Errorf("invalid duration %q: overflow", value)
+		}
+		total += d
+		rest = next
+	}
+	return total, nil
+}
+
+// nextComponent splits the first number and unit pair from the value.
+func nextComponent(value string) (string, string, string, error) {
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return "", "", "", errors.New("expected number followed by unit")
+	}
+	number := value[:i]
+	j := strings.IndexFunc(value[i:], func(r rune) bool {
+		return (r >= '0' && r <= '9') || r == '.'
+	})
+	if j == -1 {
+		j = len(value) - i
+	}
+	unit := value[i : i+j]
+	return number, unit, value[i+j:], nil
+}
+
+// ParseWithJitter parses a duration which is optionally followed by a jitter separated by a tilde like "7d~12h".
+// A zero jitter is returned when no jitter is set.
+func ParseWithJitter(value string) (time.Duration, time.Duration, error) {
+	durationValue, jitterValue, hasJitter := strings.Cut(value, jitterSeparator)
+	d, err := Parse(durationValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !hasJitter {
+		return d, 0, nil
+	}
+	jitter, err := Parse(jitterValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	return d, jitter, nil
+}
+
+// Jitter returns a duration between zero and max which is derived from the key. The same key and max will always
+// result in the same duration, which spreads out the durations of different keys without any state.
+func Jitter(key string, maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	//nolint:gosec // overflow is not possible as max jitter is positive
+	return time.Duration(h.Sum64() % uint64(maxJitter))
+}
@@ -0,0 +1,100 @@
+package duration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	type test struct {
+		name     string
+		value    string
+		expected time.Duration
+		err      bool
+	}
+
+	tests := []test{
+		{
+			name:     "go duration",
+			value:    "1h30m",
+			expected: 90 * time.Minute,
+		},
+		{
+			name:     "days",
+			value:    "7d",
+			expected: 7 * 24 * time.Hour,
+		},
+		{
+			name:     "weeks",
+			value:    "2w",
+			expected: 14 * 24 * time.Hour,
+		},
+		{
+			name:     "combined units",
+			value:    "1w2d12h",
+			expected: 9*24*time.Hour + 12*time.Hour,
+		},
+		{
+			name:     "fractional days",
+			value:    "1.5d",
+			expected: 36 * time.Hour,
+		},
+		{
+			name:  "empty",
+			value: "",
+			err:   true,
+		},
+		{
+			name:  "missing unit",
+			value: "7",
+			err:   true,
+		},
+		{
+			name:  "unknown unit",
+			value: "7y",
+			err:   true,
+		},
+		{
+			name:  "negative",
+			value: "-1d",
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Parse(tt.value)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, d)
+		})
+	}
+}
+
+func TestParseWithJitter(t *testing.T) {
+	d, jitter, err := ParseWithJitter("7d~12h")
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, d)
+	require.Equal(t, 12*time.Hour, jitter)
+
+	d, jitter, err = ParseWithJitter("24h")
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, d)
+	require.Zero(t, jitter)
+
+	_, _, err = ParseWithJitter("7d~")
+	require.Error(t, err)
+}
+
+func TestJitter(t *testing.T) {
+	require.Zero(t, Jitter("node", 0))
+	first := Jitter("node-a", 12*time.Hour)
+	require.Equal(t, first, Jitter("node-a", 12*time.Hour))
+	require.GreaterOrEqual(t, first, time.Duration(0))
+	require.Less(t, first, 12*time.Hour)
+	require.NotEqual(t, first, Jitter("node-b", 12*time.Hour))
+}
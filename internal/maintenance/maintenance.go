@@ -0,0 +1,357 @@
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDuration limits how long a window can be open, it also bounds the search when evaluating a window.
+	maxDuration = 7 * 24 * time.Hour
+	tzPrefix    = "TZ="
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring period of time. A window opens at every minute matched by its schedule and stays open for its duration.
+type Window struct {
+	spec     string
+	location *time.Location
+	duration time.Duration
+	// minutes, hours, days, months and weekdays are the allowed values for each field of the start time.
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// daysWildcard and weekdaysWildcard are true when the field starts with a wildcard. As in standard cron a start
+	// time only has to match either the day of month or the day of week when neither field is a wildcard.
+	daysWildcard     bool
+	weekdaysWildcard bool
+}
+
+// Parse parses a maintenance window specification. Two formats are supported, a weekday and time range like
+// "Mon-Fri 22:00-06:00" or a cron schedule with a duration like "0 22 * * 1-5 8h". Both formats can be prefixed
+// with a time zone like "TZ=Europe/Stockholm Sat,Sun 00:00-24:00", the default location is used otherwise.
+func Parse(spec string, defaultLocation *time.Location) (*Window, error) {
+	fields := strings.Fields(spec)
+	location := defaultLocation
+	if len(fields) > 0 && strings.HasPrefix(fields[0], tzPrefix) {
+		var err error
+		location, err = time.LoadLocation(strings.TrimPrefix(fields[0], tzPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone in maintenance window %q: %w", spec, err)
+		}
+		fields = fields[1:]
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	var window *Window
+	var err error
+	switch len(fields) {
+	case 2:
+		window, err = parseTimeRange(fields[0], fields[1])
+	case 6:
+		window, err = parseCron(fields[:5], fields[5])
+	default:
+		err = errors.New("expected weekday and time range or cron schedule and duration")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	window.spec = spec
+	window.location = location
+	return window, nil
+}
+
+// ParseList parses a semicolon separated list of maintenance windows.
+func ParseList(specs string, defaultLocation *time.Location) ([]*Window, error) {
+	windows := []*Window{}
+	for _, spec := range strings.Split(specs, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		window, err := Parse(spec, defaultLocation)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// String returns the original specification of the window.
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Contains returns true if the window is open at the given time. The start times are searched backwards, skipping
+// whole days and hours which cannot match.
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	start := t.Truncate(time.Minute)
+	for s := start; t.Sub(s) < w.duration; {
+		var next time.Time
+		switch {
+		case !w.dayMatches(s):
+			next = time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, w.location).Add(-time.Minute)
+		case !w.hours[s.Hour()]:
+			next = time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), 0, 0, 0, w.location).Add(-time.Minute)
+		case w.minutes[s.Minute()]:
+			return true
+		}
+		// Ambiguous local times during daylight saving time changes may not move the search backwards.
+		if next.IsZero() || !next.Before(s) {
+			next = s.Add(-time.Minute)
+		}
+		s = next
+	}
+	return false
+}
+
+// NextStart returns the next time the window opens after the given time. The search is limited to the coming week
+// plus a day, a zero time is returned if the window does not open within that period.
+func (w *Window) NextStart(t time.Time) time.Time {
+	t = t.In(w.location)
+	start := t.Truncate(time.Minute).Add(time.Minute)
+	for s := start; s.Sub(start) < maxDuration+24*time.Hour; {
+		var next time.Time
+		switch {
+		case !w.dayMatches(s):
+			next = time.Date(s.Year(), s.Month(), s.Day()+1, 0, 0, 0, 0, w.location)
+		case !w.hours[s.Hour()]:
+			next = time.Date(s.Year(), s.Month(), s.Day(), s.Hour()+1, 0, 0, 0, w.location)
+		case w.minutes[s.Minute()]:
+			return s
+		}
+		// Ambiguous local times during daylight saving time changes may not move the search forwards.
+		if !next.After(s) {
+			next = s.Add(time.Minute)
+		}
+		s = next
+	}
+	return time.Time{}
+}
+
+// dayMatches returns true if the day of the time matches the month and either the day of month or the day of week,
+// following standard cron where both have to match only if either field is a wildcard.
+func (w *Window) dayMatches(t time.Time) bool {
+	if !w.months[int(t.Month())] {
+		return false
+	}
+	dayMatches := w.days[t.Day()]
+	weekdayMatches := w.weekdays[int(t.Weekday())]
+	if w.daysWildcard || w.weekdaysWildcard {
+		return dayMatches && weekdayMatches
+	}
+	return dayMatches || weekdayMatches
+}
+
+// Contains returns true if any of the windows is open or if there are no windows.
+func Contains(windows []*Window, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns the earliest next start of any of the windows.
+func NextStart(windows []*Window, t time.Time) time.Time {
+	next := time.Time{}
+	for _, window := range windows {
+		start := window.NextStart(t)
+		if start.IsZero() {
+			continue
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+func parseTimeRange(daySpec, timeSpec string) (*Window, error) {
+	days, err := parseWeekdays(daySpec)
+	if err != nil {
+		return nil, err
+	}
+	from, to, ok := strings.Cut(timeSpec, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected time range in format HH:MM-HH:MM: %s", timeSpec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	if start == 24*time.Hour {
+		return nil, fmt.Errorf("time range cannot start at end of day: %s", timeSpec)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	duration := end - start
+	if duration <= 0 {
+		// Time ranges which end before they start continue into the next day.
+		duration += 24 * time.Hour
+	}
+	startMinutes := int(start / time.Minute)
+	return &Window{
+		duration:     duration,
+		minutes:      map[int]bool{startMinutes % 60: true},
+		hours:        map[int]bool{startMinutes / 60: true},
+		days:         allValues(1, 31),
+		months:       allValues(1, 12),
+		weekdays:     days,
+		daysWildcard: true,
+	}, nil
+}
+
+func parseWeekdays(spec string) (map[int]bool, error) {
+	if spec == "*" {
+		return allValues(0, 6), nil
+	}
+	days := map[int]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday: %s", from)
+		}
+		if !isRange {
+			days[int(first)] = true
+			continue
+		}
+		last, ok := weekdays[strings.ToLower(to)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday: %s", to)
+		}
+		// Ranges may wrap around the end of the week, like Sat-Mon.
+		for d := int(first); ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == int(last) {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a time of day in the format HH:MM where 24:00 is allowed to represent the end of the day.
+func parseClock(spec string) (time.Duration, error) {
+	hourSpec, minuteSpec, ok := strings.Cut(spec, ":")
+	if !ok {
+		return 0, fmt.Errorf("expected time in format HH:MM: %s", spec)
+	}
+	hour, err := strconv.Atoi(hourSpec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hour: %s", hourSpec)
+	}
+	minute, err := strconv.Atoi(minuteSpec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minute: %s", minuteSpec)
+	}
+	if hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("time out of range: %s", spec)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
+
+func parseCron(fields []string, durationSpec string) (*Window, error) {
+	duration, err := time.ParseDuration(durationSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration: %s", durationSpec)
+	}
+	if duration <= 0 || duration > maxDuration {
+		return nil, fmt.Errorf("duration has to be larger than zero and at most %s: %s", maxDuration, durationSpec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	values := [5]map[int]bool{}
+	for i, field := range fields {
+		values[i], err = parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Both 0 and 7 represent Sunday.
+	if values[4][7] {
+		values[4][0] = true
+	}
+	return &Window{
+		duration:         duration,
+		minutes:          values[0],
+		hours:            values[1],
+		days:             values[2],
+		months:           values[3],
+		weekdays:         values[4],
+		daysWildcard:     strings.HasPrefix(fields[2], "*"),
+		weekdaysWildcard: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField parses a single cron field supporting wildcards, values, ranges, lists and steps.
+func parseCronField(field string, lower, upper int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepSpec)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in cron field: %s", part)
+			}
+		}
+		first, last := lower, upper
+		if rangeSpec != "*" {
+			from, to, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			first, err = strconv.Atoi(from)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in cron field: %s", part)
+			}
+			last = first
+			if isRange {
+				last, err = strconv.Atoi(to)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value in cron field: %s", part)
+				}
+			} else if hasStep {
+				last = upper
+			}
+		}
+		if first < lower || last > upper || first > last {
+			return nil, fmt.Errorf("value out of range in cron field: %s", part)
+		}
+		for v := first; v <= last; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func allValues(lower, upper int) map[int]bool {
+	values := map[int]bool{}
+	for v := lower; v <= upper; v++ {
+		values[v] = true
+	}
+	return values
+}
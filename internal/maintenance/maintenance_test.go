@@ -0,0 +1,176 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContains(t *testing.T) {
+	type test struct {
+		name     string
+		spec     string
+		time     string
+		contains bool
+	}
+
+	tests := []test{
+		{
+			name:     "inside weekday range",
+			spec:     "Mon-Fri 22:00-06:00",
+			time:     "2025-04-22T23:30:00Z",
+			contains: true,
+		},
+		{
+			name:     "inside range continuing into next day",
+			spec:     "Mon-Fri 22:00-06:00",
+			time:     "2025-04-26T05:59:00Z",
+			contains: true,
+		},
+		{
+			name:     "outside range on weekend",
+			spec:     "Mon-Fri 22:00-06:00",
+			time:     "2025-04-26T22:30:00Z",
+			contains: false,
+		},
+		{
+			name:     "end of range is exclusive",
+			spec:     "Mon-Fri 22:00-06:00",
+			time:     "2025-04-23T06:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "full day list",
+			spec:     "Sat,Sun 00:00-24:00",
+			time:     "2025-04-27T23:59:00Z",
+			contains: true,
+		},
+		{
+			name:     "wildcard weekday",
+			spec:     "* 01:00-05:00",
+			time:     "2025-04-24T03:00:00Z",
+			contains: true,
+		},
+		{
+			name:     "time zone",
+			spec:     "TZ=Europe/Stockholm Mon-Fri 22:00-23:00",
+			time:     "2025-04-22T20:30:00Z",
+			contains: true,
+		},
+		{
+			name:     "cron inside",
+			spec:     "0 22 * * 1-5 8h",
+			time:     "2025-04-23T04:00:00Z",
+			contains: true,
+		},
+		{
+			name:     "cron outside",
+			spec:     "0 22 * * 1-5 8h",
+			time:     "2025-04-23T12:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "cron with step",
+			spec:     "*/30 * * * * 10m",
+			time:     "2025-04-23T12:35:00Z",
+			contains: true,
+		},
+		{
+			name:     "cron day of month or day of week",
+			spec:     "0 2 1 * 0 4h",
+			time:     "2025-04-27T03:00:00Z",
+			contains: true,
+		},
+		{
+			name:     "cron day of month or day of week outside",
+			spec:     "0 2 1 * 0 4h",
+			time:     "2025-04-28T03:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "cron wildcard day of month and day of week",
+			spec:     "0 2 */2 * 1 4h",
+			time:     "2025-04-28T03:00:00Z",
+			contains: false,
+		},
+		{
+			name:     "cron duration spanning days",
+			spec:     "30 23 * * 5 49h",
+			time:     "2025-04-27T23:59:00Z",
+			contains: true,
+		},
+		{
+			name:     "cron with step outside",
+			spec:     "*/30 * * * * 10m",
+			time:     "2025-04-23T12:45:00Z",
+			contains: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := Parse(tt.spec, time.UTC)
+			require.NoError(t, err)
+			now, err := time.Parse(time.RFC3339, tt.time)
+			require.NoError(t, err)
+			require.Equal(t, tt.contains, window.Contains(now))
+		})
+	}
+}
+
+func TestNextStart(t *testing.T) {
+	window, err := Parse("Sat,Sun 02:00-04:00", time.UTC)
+	require.NoError(t, err)
+	now, err := time.Parse(time.RFC3339, "2025-04-22T12:00:00Z")
+	require.NoError(t, err)
+	expected, err := time.Parse(time.RFC3339, "2025-04-26T02:00:00Z")
+	require.NoError(t, err)
+	require.True(t, expected.Equal(window.NextStart(now)))
+
+	window, err = Parse("15 3 1 * 0 1h", time.UTC)
+	require.NoError(t, err)
+	expected, err = time.Parse(time.RFC3339, "2025-04-27T03:15:00Z")
+	require.NoError(t, err)
+	require.True(t, expected.Equal(window.NextStart(now)))
+
+	// Searching across a daylight saving time change.
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+	window, err = Parse("30 2 * * * 1h", stockholm)
+	require.NoError(t, err)
+	now, err = time.Parse(time.RFC3339, "2025-10-25T12:00:00Z")
+	require.NoError(t, err)
+	next := window.NextStart(now)
+	require.Equal(t, 2, next.In(stockholm).Hour())
+	require.Equal(t, 30, next.In(stockholm).Minute())
+	require.True(t, window.Contains(next))
+}
+
+func TestParseInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"Mon-Fri",
+		"Foo 22:00-06:00",
+		"Mon 25:00-06:00",
+		"Mon 24:00-06:00",
+		"TZ=Foo/Bar Mon 22:00-06:00",
+		"0 22 * * 1-5",
+		"0 22 * * 1-5 foo",
+		"60 22 * * 1-5 1h",
+		"0 22 * * 1-5 200h",
+	}
+	for _, spec := range specs {
+		_, err := Parse(spec, time.UTC)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	windows, err := ParseList("Mon-Fri 22:00-06:00; Sat,Sun 00:00-24:00", time.UTC)
+	require.NoError(t, err)
+	require.Len(t, windows, 2)
+	now, err := time.Parse(time.RFC3339, "2025-04-26T12:00:00Z")
+	require.NoError(t, err)
+	require.True(t, Contains(windows, now))
+	require.True(t, Contains(nil, now))
+}
@@ -0,0 +1,138 @@
+package status
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	legacyNameRegexp      = regexp.MustCompile(`^\s*Name:\s+(\S+)\s*$`)
+	legacyConditionRegexp = regexp.MustCompile(`^\s*(Health|ScaleUp|ScaleDown):\s+(\S+)(?:\s+\((.*)\))?\s*$`)
+	legacyTimeRegexp      = regexp.MustCompile(`^\s*(LastProbeTime|LastTransitionTime):\s+(.+)$`)
+	legacyCountRegexp     = regexp.MustCompile(`(\w+)=(\d+)`)
+)
+
+// legacyTarget contains the conditions which the lines being parsed belong to, either cluster wide or of a node group.
+type legacyTarget struct {
+	health    *HealthType
+	scaleUp   *ScaleUpType
+	scaleDown *ScaleDownType
+}
+
+// parseLegacy parses the legacy text format, where each condition is written as the condition type and status followed by
+// counts in parentheses, like "Health: Healthy (ready=3 unready=0 registered=3)", and the probe and transition times.
+//
+//nolint:cyclop //ignore
+func parseLegacy(s string) (*ClusterAutoscalerStatus, error) {
+	status := &ClusterAutoscalerStatus{Format: FormatLegacy}
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimSpace(s)))
+	if !scanner.Scan() {
+		return nil, errors.New("cluster autoscaler status is empty")
+	}
+	header := strings.TrimSuffix(strings.TrimPrefix(scanner.Text(), legacyHeader), ":")
+	t, err := parseStatusTime(header)
+	if err != nil {
+		return nil, err
+	}
+	status.Time = t
+
+	var target *legacyTarget
+	// probeTime and transitionTime belong to the last parsed condition.
+	var probeTime, transitionTime *time.Time
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.TrimSpace(line) {
+		case "Cluster-wide:":
+			c := &status.ClusterWide
+			target = &legacyTarget{health: &c.Health, scaleUp: &c.ScaleUp, scaleDown: &c.ScaleDown}
+			continue
+		case "NodeGroups:":
+			target = nil
+			continue
+		}
+		if matches := legacyNameRegexp.FindStringSubmatch(line); matches != nil {
+			ng := &NodeGroupsType{Name: matches[1]}
+			status.NodeGroups = append(status.NodeGroups, ng)
+			target = &legacyTarget{health: &ng.Health, scaleUp: &ng.ScaleUp, scaleDown: &ng.ScaleDown}
+			continue
+		}
+		if matches := legacyConditionRegexp.FindStringSubmatch(line); matches != nil {
+			if target == nil {
+				return nil, fmt.Errorf("could not parse cluster autoscaler status: condition outside of cluster wide or node group: %s", line)
+			}
+			counts, err := parseLegacyCounts(matches[3])
+			if err != nil {
+				return nil, err
+			}
+			switch matches[1] {
+			case "Health":
+				setLegacyHealth(target.health, matches[2], counts)
+				probeTime, transitionTime = &target.health.LastProbeTime, &target.health.LastTransitionTime
+			case "ScaleUp":
+				target.scaleUp.Status = matches[2]
+				probeTime, transitionTime = &target.scaleUp.LastProbeTime, &target.scaleUp.LastTransitionTime
+			case "ScaleDown":
+				target.scaleDown.Status = matches[2]
+				target.scaleDown.Candidates = counts["candidates"]
+				probeTime, transitionTime = &target.scaleDown.LastProbeTime, &target.scaleDown.LastTransitionTime
+			}
+			continue
+		}
+		if matches := legacyTimeRegexp.FindStringSubmatch(line); matches != nil {
+			if probeTime == nil {
+				return nil, fmt.Errorf("could not parse cluster autoscaler status: time without condition: %s", line)
+			}
+			t, err := parseStatusTime(matches[2])
+			if err != nil {
+				return nil, err
+			}
+			if matches[1] == "LastProbeTime" {
+				*probeTime = t
+			} else {
+				*transitionTime = t
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read cluster autoscaler status: %w", err)
+	}
+	return status, nil
+}
+
+// parseLegacyCounts returns the counts in a condition message like "ready=3 unready=0 (resourceUnready=0)".
+func parseLegacyCounts(message string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, matches := range legacyCountRegexp.FindAllStringSubmatch(message, -1) {
+		value, err := strconv.Atoi(matches[2])
+		if err != nil {
+			return nil, fmt.Errorf("could not convert %s count to int: %w", matches[1], err)
+		}
+		counts[matches[1]] = value
+	}
+	return counts, nil
+}
+
+func setLegacyHealth(health *HealthType, status string, counts map[string]int) {
+	health.Status = status
+	health.NodeCounts = NodeCountsType{
+		Registered: RegisteredType{
+			Total:      counts["registered"],
+			Ready:      counts["ready"],
+			NotStarted: counts["notStarted"],
+			Unready: UnreadyType{
+				Total:           counts["unready"],
+				ResourceUnready: counts["resourceUnready"],
+			},
+		},
+		LongUnregistered: counts["longUnregistered"],
+		Unregistered:     counts["unregistered"],
+	}
+	health.CloudProviderTarget = counts["cloudProviderTarget"]
+	health.MinSize = counts["minSize"]
+	health.MaxSize = counts["maxSize"]
+}
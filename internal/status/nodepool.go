@@ -0,0 +1,269 @@
+package status
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	GKENodePoolLabelKey                     = "cloud.google.com/gke-nodepool"
+	KarpenterNodePoolLabelKey               = "karpenter.sh/nodepool"
+	KarpenterProvisionerLabelKey            = "karpenter.sh/provisioner-name"
+	ClusterAPIDeploymentLabelKey            = "cluster.x-k8s.io/deployment-name"
+	ClusterAPIClusterNamespaceAnnotationKey = "cluster.x-k8s.io/cluster-namespace"
+	ClusterAPIOwnerKindAnnotationKey        = "cluster.x-k8s.io/owner-kind"
+	ClusterAPIOwnerNameAnnotationKey        = "cluster.x-k8s.io/owner-name"
+)
+
+// NodePoolResolver determines which node pool a node belongs to. Node pools are named differently by each cloud
+// provider and node provisioner, both in the node labels and in the cluster autoscaler status.
+type NodePoolResolver interface {
+	// NodePoolLabel returns the key and value of the label which selects all nodes in the node pool of the node.
+	NodePoolLabel(node *corev1.Node) (string, string, error)
+	// NodePoolName returns a regular expression matching the name of the node pool in the cluster autoscaler status.
+	// The expression is also used to group nodes by node pool.
+	NodePoolName(node *corev1.Node) (string, error)
+}
+
+// NodePoolResolverOptions configures the node pool resolvers which require configuration.
+type NodePoolResolverOptions struct {
+	// LabelKey is the node pool label used by the label resolver.
+	LabelKey string
+	// NameTemplate is the template rendering the node pool name of the label resolver, like "{{ .Value }}".
+	NameTemplate string
+}
+
+// nodePoolResolvers contains the constructor of each node pool resolver by name.
+var nodePoolResolvers = map[string]func(NodePoolResolverOptions) (NodePoolResolver, error){
+	"Default": func(NodePoolResolverOptions) (NodePoolResolver, error) {
+		return DefaultNodePoolResolver{}, nil
+	},
+	"GKE": func(NodePoolResolverOptions) (NodePoolResolver, error) {
+		return GKENodePoolResolver{}, nil
+	},
+	"ClusterAPI": func(NodePoolResolverOptions) (NodePoolResolver, error) {
+		return ClusterAPINodePoolResolver{}, nil
+	},
+	"Karpenter": func(NodePoolResolverOptions) (NodePoolResolver, error) {
+		return KarpenterNodePoolResolver{}, nil
+	},
+	"Label": func(opts NodePoolResolverOptions) (NodePoolResolver, error) {
+		return NewLabelNodePoolResolver(opts.LabelKey, opts.NameTemplate)
+	},
+}
+
+// NodePoolResolverNames returns the names of all node pool resolvers in alphabetical order.
+func NodePoolResolverNames() []string {
+	names := []string{}
+	for name := range nodePoolResolvers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewNodePoolResolver returns the node pool resolver with the name.
+func NewNodePoolResolver(name string, opts NodePoolResolverOptions) (NodePoolResolver, error) {
+	newResolver, ok := nodePoolResolvers[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid node pool resolver %s, expected one of %s", name, strings.Join(NodePoolResolverNames(), ", "))
+	}
+	return newResolver(opts)
+}
+
+// DefaultNodePoolResolver supports AKS, EKS and kubemark node pools.
+type DefaultNodePoolResolver struct{}
+
+// NodePoolLabel returns the first node pool label found on the node.
+func (DefaultNodePoolResolver) NodePoolLabel(node *corev1.Node) (string, string, error) {
+	for _, key := range getNodePoolLabelKeys() {
+		value, ok := node.Labels[key]
+		if ok {
+			return key, value, nil
+		}
+	}
+	return "", "", fmt.Errorf("could not find node pool label in node: %s", node.Name)
+}
+
+// NodePoolName returns the name of the VMSS for AKS nodes, the name of the ASG for EKS nodes and the label value for
+// kubemark nodes.
+func (DefaultNodePoolResolver) NodePoolName(node *corev1.Node) (string, error) {
+	for _, key := range getNodePoolLabelKeys() {
+		//nolint:staticcheck // ignore this
+		nodePoolName, ok := node.ObjectMeta.Labels[key]
+		if !ok {
+			continue
+		}
+
+		// Custom handling for different cloud provider is required because Cluster Autoscaler will use VMSS or ASG names for the pool name.
+		switch key {
+		case AzureNodePoolLabelKey:
+			// Azure agent pool label will only give the pool name used when creating it in AKS. The pool name used in the CA
+			// status is the name of the VMSS automatically created by AKS. The Node name will be the same as the VMSS name with
+			// a unique instance suffix. The label fetching is only done to check for an AKS cluster. The Node name with the
+			// suffix removed is instead used as the pool name.
+			nodePoolName = node.Name[:strings.LastIndex(node.Name, "-vmss")+5]
+		case AWSNodePoolLabelKey:
+			// AWS will use the generated ASG name for the pool name in the CA. This value cannot be found in the Node metadata.
+			// The name is however, predicatable as it will be the same as the EKS node pool name with an additional UUID as a
+			// suffix. This is why the UUID regex has to be appended to the end.
+			nodePoolName = fmt.Sprintf("eks-%s-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}", nodePoolName)
+		}
+		return nodePoolName, nil
+	}
+	return "", fmt.Errorf("could not find node pool label in node: %s", node.Name)
+}
+
+// GKENodePoolResolver supports GKE node pools.
+type GKENodePoolResolver struct{}
+
+// NodePoolLabel returns the GKE node pool label.
+func (GKENodePoolResolver) NodePoolLabel(node *corev1.Node) (string, string, error) {
+	value, ok := node.Labels[GKENodePoolLabelKey]
+	if !ok {
+		return "", "", fmt.Errorf("could not find node pool label in node: %s", node.Name)
+	}
+	return GKENodePoolLabelKey, value, nil
+}
+
+// NodePoolName returns the name of the managed instance group of the node. GKE names nodes after the instance group,
+// replacing the "grp" suffix with a unique instance suffix. Cluster autoscaler may report the instance group by name or
+// by its full URL.
+func (r GKENodePoolResolver) NodePoolName(node *corev1.Node) (string, error) {
+	if _, _, err := r.NodePoolLabel(node); err != nil {
+		return "", err
+	}
+	i := strings.LastIndex(node.Name, "-")
+	if i <= 0 {
+		return "", fmt.Errorf("could not determine instance group of node: %s", node.Name)
+	}
+	return fmt.Sprintf("(?:.*/)?%s-grp", regexp.QuoteMeta(node.Name[:i])), nil
+}
+
+// ClusterAPINodePoolResolver supports Cluster API MachineDeployments, MachineSets and MachinePools.
+type ClusterAPINodePoolResolver struct{}
+
+// NodePoolLabel returns the MachineDeployment label, which is only set when it is propagated to the node.
+func (ClusterAPINodePoolResolver) NodePoolLabel(node *corev1.Node) (string, string, error) {
+	value, ok := node.Labels[ClusterAPIDeploymentLabelKey]
+	if !ok {
+		return "", "", fmt.Errorf("could not find node pool label in node: %s", node.Name)
+	}
+	return ClusterAPIDeploymentLabelKey, value, nil
+}
+
+// NodePoolName returns the node group of the owner of the node as reported by the cluster autoscaler Cluster API
+// provider, like "MachineDeployment/namespace/name". Nodes owned by a MachineSet match both the MachineSet and the
+// MachineDeployment which created it, as the MachineSet name is the MachineDeployment name with a random suffix.
+func (ClusterAPINodePoolResolver) NodePoolName(node *corev1.Node) (string, error) {
+	namespace, ok := node.Annotations[ClusterAPIClusterNamespaceAnnotationKey]
+	if !ok {
+		return "", fmt.Errorf("could not find cluster namespace annotation in node: %s", node.Name)
+	}
+	kind := node.Annotations[ClusterAPIOwnerKindAnnotationKey]
+	name := node.Annotations[ClusterAPIOwnerNameAnnotationKey]
+	if kind == "" || name == "" {
+		return "", fmt.Errorf("could not find owner annotations in node: %s", node.Name)
+	}
+	if kind != "MachineSet" {
+		return regexp.QuoteMeta(fmt.Sprintf("%s/%s/%s", kind, namespace, name)), nil
+	}
+	deployment, ok := node.Labels[ClusterAPIDeploymentLabelKey]
+	if !ok {
+		i := strings.LastIndex(name, "-")
+		if i <= 0 {
+			return regexp.QuoteMeta(fmt.Sprintf("MachineSet/%s/%s", namespace, name)), nil
+		}
+		deployment = name[:i]
+	}
+	return fmt.Sprintf("(?:%s|%s)",
+		regexp.QuoteMeta(fmt.Sprintf("MachineDeployment/%s/%s", namespace, deployment)),
+		regexp.QuoteMeta(fmt.Sprintf("MachineSet/%s/%s", namespace, name)),
+	), nil
+}
+
+// KarpenterNodePoolResolver supports Karpenter NodePools and the Provisioners used by older Karpenter versions.
+type KarpenterNodePoolResolver struct{}
+
+// NodePoolLabel returns the NodePool label, or the Provisioner label for nodes created by older Karpenter versions.
+func (KarpenterNodePoolResolver) NodePoolLabel(node *corev1.Node) (string, string, error) {
+	for _, key := range []string{KarpenterNodePoolLabelKey, KarpenterProvisionerLabelKey} {
+		value, ok := node.Labels[key]
+		if ok {
+			return key, value, nil
+		}
+	}
+	return "", "", fmt.Errorf("could not find node pool label in node: %s", node.Name)
+}
+
+// NodePoolName returns the name of the NodePool.
+func (r KarpenterNodePoolResolver) NodePoolName(node *corev1.Node) (string, error) {
+	_, value, err := r.NodePoolLabel(node)
+	if err != nil {
+		return "", err
+	}
+	return regexp.QuoteMeta(value), nil
+}
+
+// NodePoolNameData is passed to the name template of the label resolver.
+type NodePoolNameData struct {
+	// Value is the value of the node pool label.
+	Value string
+	// Node is the name of the node.
+	Node string
+	// Labels are the labels of the node.
+	Labels map[string]string
+}
+
+// LabelNodePoolResolver identifies node pools by a configured label, rendering the node pool name from a template.
+type LabelNodePoolResolver struct {
+	key          string
+	nameTemplate *template.Template
+}
+
+// NewLabelNodePoolResolver returns a resolver using the label key. The name template is rendered with NodePoolNameData
+// and the quoted label value is used as name when the template is empty. Templates can quote values with the quote
+// function so that they are matched literally instead of as regular expressions.
+func NewLabelNodePoolResolver(key, nameTemplate string) (*LabelNodePoolResolver, error) {
+	if key == "" {
+		return nil, fmt.Errorf("node pool label key cannot be empty")
+	}
+	if nameTemplate == "" {
+		nameTemplate = "{{ quote .Value }}"
+	}
+	funcs := template.FuncMap{"quote": regexp.QuoteMeta}
+	tmpl, err := template.New("name").Option("missingkey=error").Funcs(funcs).Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node pool name template: %w", err)
+	}
+	return &LabelNodePoolResolver{key: key, nameTemplate: tmpl}, nil
+}
+
+// NodePoolLabel returns the configured label.
+func (r *LabelNodePoolResolver) NodePoolLabel(node *corev1.Node) (string, string, error) {
+	value, ok := node.Labels[r.key]
+	if !ok {
+		return "", "", fmt.Errorf("could not find node pool label %s in node: %s", r.key, node.Name)
+	}
+	return r.key, value, nil
+}
+
+// NodePoolName returns the rendered name template.
+func (r *LabelNodePoolResolver) NodePoolName(node *corev1.Node) (string, error) {
+	_, value, err := r.NodePoolLabel(node)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = r.nameTemplate.Execute(buf, NodePoolNameData{Value: value, Node: node.Name, Labels: node.Labels})
+	if err != nil {
+		return "", fmt.Errorf("could not render node pool name of node %s: %w", node.Name, err)
+	}
+	return buf.String(), nil
+}
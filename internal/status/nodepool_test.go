@@ -0,0 +1,193 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewNodePoolResolver(t *testing.T) {
+	for _, name := range []string{"Default", "GKE", "ClusterAPI", "Karpenter"} {
+		_, err := NewNodePoolResolver(name, NodePoolResolverOptions{})
+		require.NoError(t, err)
+	}
+	_, err := NewNodePoolResolver("Label", NodePoolResolverOptions{})
+	require.EqualError(t, err, "node pool label key cannot be empty")
+	_, err = NewNodePoolResolver("Label", NodePoolResolverOptions{LabelKey: "pool", NameTemplate: "{{ .Value"})
+	require.Error(t, err)
+	_, err = NewNodePoolResolver("Label", NodePoolResolverOptions{LabelKey: "pool"})
+	require.NoError(t, err)
+	_, err = NewNodePoolResolver("Foo", NodePoolResolverOptions{})
+	require.EqualError(t, err, "invalid node pool resolver Foo, expected one of ClusterAPI, Default, GKE, Karpenter, Label")
+}
+
+func TestNodePoolResolvers(t *testing.T) {
+	labelResolver, err := NewLabelNodePoolResolver("example.com/pool", `{{ index .Labels "example.com/cluster" }}-{{ .Value }}`)
+	require.NoError(t, err)
+	defaultLabelResolver, err := NewLabelNodePoolResolver("example.com/pool", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		resolver    NodePoolResolver
+		node        *corev1.Node
+		labelKey    string
+		labelValue  string
+		statusNames []string
+		otherNames  []string
+	}{
+		{
+			name:     "gke",
+			resolver: GKENodePoolResolver{},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "gke-prod-default-pool-7d3f9a21-x8kq",
+					Labels: map[string]string{GKENodePoolLabelKey: "default-pool"},
+				},
+			},
+			labelKey:   GKENodePoolLabelKey,
+			labelValue: "default-pool",
+			statusNames: []string{
+				"gke-prod-default-pool-7d3f9a21-grp",
+				"https://www.googleapis.com/compute/v1/projects/prod/zones/europe-west1-b/instanceGroups/gke-prod-default-pool-7d3f9a21-grp",
+			},
+			otherNames: []string{"gke-prod-default-pool-5b2e8c10-grp"},
+		},
+		{
+			name:     "cluster api machine set",
+			resolver: ClusterAPINodePoolResolver{},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "workers-5f7b9-abcde",
+					Annotations: map[string]string{
+						ClusterAPIClusterNamespaceAnnotationKey: "clusters",
+						ClusterAPIOwnerKindAnnotationKey:        "MachineSet",
+						ClusterAPIOwnerNameAnnotationKey:        "workers-5f7b9",
+					},
+				},
+			},
+			statusNames: []string{"MachineDeployment/clusters/workers", "MachineSet/clusters/workers-5f7b9"},
+			otherNames:  []string{"MachineDeployment/clusters/workers-5f7b9", "MachineDeployment/other/workers"},
+		},
+		{
+			name:     "cluster api machine pool",
+			resolver: ClusterAPINodePoolResolver{},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pool-0",
+					Labels: map[string]string{
+						ClusterAPIDeploymentLabelKey: "workers",
+					},
+					Annotations: map[string]string{
+						ClusterAPIClusterNamespaceAnnotationKey: "clusters",
+						ClusterAPIOwnerKindAnnotationKey:        "MachinePool",
+						ClusterAPIOwnerNameAnnotationKey:        "pool",
+					},
+				},
+			},
+			labelKey:    ClusterAPIDeploymentLabelKey,
+			labelValue:  "workers",
+			statusNames: []string{"MachinePool/clusters/pool"},
+			otherNames:  []string{"MachineDeployment/clusters/workers"},
+		},
+		{
+			name:     "karpenter",
+			resolver: KarpenterNodePoolResolver{},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "ip-10-0-1-12.eu-west-1.compute.internal",
+					Labels: map[string]string{KarpenterNodePoolLabelKey: "general"},
+				},
+			},
+			labelKey:    KarpenterNodePoolLabelKey,
+			labelValue:  "general",
+			statusNames: []string{"general"},
+			otherNames:  []string{"general-purpose"},
+		},
+		{
+			name:     "karpenter provisioner",
+			resolver: KarpenterNodePoolResolver{},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "ip-10-0-1-13.eu-west-1.compute.internal",
+					Labels: map[string]string{KarpenterProvisionerLabelKey: "default"},
+				},
+			},
+			labelKey:    KarpenterProvisionerLabelKey,
+			labelValue:  "default",
+			statusNames: []string{"default"},
+		},
+		{
+			name:     "label",
+			resolver: labelResolver,
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node",
+					Labels: map[string]string{"example.com/pool": "workers", "example.com/cluster": "prod"},
+				},
+			},
+			labelKey:    "example.com/pool",
+			labelValue:  "workers",
+			statusNames: []string{"prod-workers"},
+			otherNames:  []string{"workers"},
+		},
+		{
+			name:     "label default template",
+			resolver: defaultLabelResolver,
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node",
+					Labels: map[string]string{"example.com/pool": "workers.v2"},
+				},
+			},
+			labelKey:    "example.com/pool",
+			labelValue:  "workers.v2",
+			statusNames: []string{"workers.v2"},
+			otherNames:  []string{"workers-v2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, value, err := tt.resolver.NodePoolLabel(tt.node)
+			if tt.labelKey == "" {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.labelKey, key)
+				require.Equal(t, tt.labelValue, value)
+			}
+
+			nodePoolName, err := tt.resolver.NodePoolName(tt.node)
+			require.NoError(t, err)
+			for _, name := range tt.statusNames {
+				status, err := Parse(mockClusterAutoscalerStatus(t, []testNodePool{{name: name, ready: 2, min: 1}}))
+				require.NoError(t, err)
+				ng, err := status.NodeGroup(nodePoolName)
+				require.NoError(t, err, name)
+				require.Equal(t, 1, ng.Health.MinSize)
+			}
+			for _, name := range tt.otherNames {
+				status, err := Parse(mockClusterAutoscalerStatus(t, []testNodePool{{name: name, ready: 2, min: 1}}))
+				require.NoError(t, err)
+				_, err = status.NodeGroup(nodePoolName)
+				require.Error(t, err, name)
+			}
+		})
+	}
+}
+
+func TestNodePoolResolversMissingLabel(t *testing.T) {
+	labelResolver, err := NewLabelNodePoolResolver("example.com/pool", "")
+	require.NoError(t, err)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}
+	for _, resolver := range []NodePoolResolver{
+		DefaultNodePoolResolver{}, GKENodePoolResolver{}, ClusterAPINodePoolResolver{}, KarpenterNodePoolResolver{}, labelResolver,
+	} {
+		_, _, err := resolver.NodePoolLabel(node)
+		require.Error(t, err)
+		_, err = resolver.NodePoolName(node)
+		require.Error(t, err)
+	}
+}
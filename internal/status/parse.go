@@ -0,0 +1,68 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// legacyHeader is the start of the legacy status, followed by the time of the status.
+const legacyHeader = "Cluster-autoscaler status at "
+
+// yamlStatus is the YAML format of the status, the time is not in a format which can be decoded directly.
+type yamlStatus struct {
+	Time             string            `yaml:"time"`
+	AutoscalerStatus string            `yaml:"autoscalerStatus"`
+	Message          string            `yaml:"message"`
+	ClusterWide      ClusterWideType   `yaml:"clusterWide"`
+	NodeGroups       []*NodeGroupsType `yaml:"nodeGroups"`
+}
+
+// Parse parses the cluster autoscaler status, detecting the format from the content. Unknown fields and lines are
+// ignored so that statuses written by newer cluster autoscaler versions can still be parsed.
+func Parse(s string) (*ClusterAutoscalerStatus, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), legacyHeader) {
+		return parseLegacy(s)
+	}
+	return parseYAML(s)
+}
+
+func parseYAML(s string) (*ClusterAutoscalerStatus, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errors.New("cluster autoscaler status is empty")
+	}
+	raw := yamlStatus{}
+	err := yaml.Unmarshal([]byte(s), &raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse cluster autoscaler status: %w", err)
+	}
+	status := &ClusterAutoscalerStatus{
+		Format:           FormatYAML,
+		AutoscalerStatus: raw.AutoscalerStatus,
+		Message:          raw.Message,
+		ClusterWide:      raw.ClusterWide,
+		NodeGroups:       raw.NodeGroups,
+	}
+	if raw.Time != "" {
+		status.Time, err = parseStatusTime(raw.Time)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// parseStatusTime parses a time formatted with time.Time.String, which is how cluster autoscaler writes times outside
+// of the YAML time fields.
+func parseStatusTime(value string) (time.Time, error) {
+	// Remove the monotonic clock reading which is added to times read from the local clock.
+	value, _, _ = strings.Cut(strings.TrimSpace(value), " m=")
+	t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse cluster autoscaler status time: %w", err)
+	}
+	return t, nil
+}
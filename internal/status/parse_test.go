@@ -0,0 +1,189 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStatusFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestParseFixtures(t *testing.T) {
+	type nodeGroup struct {
+		name          string
+		health        string
+		ready         int
+		registered    int
+		unready       int
+		target        int
+		minSize       int
+		maxSize       int
+		scaleUp       string
+		scaleDown     string
+		candidates    int
+		backoffReason string
+	}
+	tests := []struct {
+		fixture          string
+		format           Format
+		time             time.Time
+		autoscalerStatus string
+		clusterReady     int
+		clusterScaleUp   string
+		clusterProbeTime time.Time
+		nodeGroups       []nodeGroup
+	}{
+		{
+			fixture:          "legacy-v1.21.txt",
+			format:           FormatLegacy,
+			time:             time.Date(2021, 9, 14, 7, 12, 45, 118734596, time.UTC),
+			clusterReady:     4,
+			clusterScaleUp:   "NoActivity",
+			clusterProbeTime: time.Date(2021, 9, 14, 7, 12, 44, 969383541, time.UTC),
+			nodeGroups: []nodeGroup{
+				{
+					name: "aks-system-31415926-vmss", health: "Healthy", ready: 1, registered: 1, target: 1, minSize: 1, maxSize: 3,
+					scaleUp: "NoActivity", scaleDown: "NoCandidates",
+				},
+				{
+					name: "aks-user-27182818-vmss", health: "Healthy", ready: 3, registered: 3, target: 3, minSize: 2, maxSize: 10,
+					scaleUp: "NoActivity", scaleDown: "CandidatesPresent", candidates: 1,
+				},
+			},
+		},
+		{
+			fixture:          "legacy-v1.28.txt",
+			format:           FormatLegacy,
+			time:             time.Date(2024, 2, 6, 12, 31, 9, 531267902, time.UTC),
+			clusterReady:     5,
+			clusterScaleUp:   "InProgress",
+			clusterProbeTime: time.Date(2024, 2, 6, 12, 31, 9, 326102155, time.UTC),
+			nodeGroups: []nodeGroup{
+				{
+					name: "eks-dev-eks2-system-52c7a2b4-6d2f-4e35-9c1d-0a8b3f5e7d91", health: "Healthy", ready: 2, registered: 2, target: 2,
+					minSize: 2, maxSize: 4, scaleUp: "NoActivity", scaleDown: "NoCandidates",
+				},
+				{
+					name: "eks-dev-eks2-user-0f4e9b1c-2a3d-4b5e-8f6a-7b8c9d0e1f2a", health: "Healthy", ready: 3, registered: 4, unready: 1,
+					target: 5, minSize: 1, maxSize: 10, scaleUp: "InProgress", scaleDown: "NoCandidates",
+				},
+			},
+		},
+		{
+			fixture:          "yaml-v1.30.yaml",
+			format:           FormatYAML,
+			time:             time.Date(2024, 8, 21, 9, 15, 32, 874013294, time.UTC),
+			autoscalerStatus: "Running",
+			clusterReady:     3,
+			clusterScaleUp:   "NoActivity",
+			clusterProbeTime: time.Date(2024, 8, 21, 9, 15, 32, 874013294, time.UTC),
+			nodeGroups: []nodeGroup{
+				{
+					name: "kubemark-pool", health: "Healthy", ready: 3, registered: 3, target: 3, minSize: 3, maxSize: 6,
+					scaleUp: "NoActivity", scaleDown: "NoCandidates",
+				},
+			},
+		},
+		{
+			fixture:          "yaml-v1.32.yaml",
+			format:           FormatYAML,
+			time:             time.Date(2025, 4, 22, 14, 29, 8, 360891242, time.UTC),
+			autoscalerStatus: "Running",
+			clusterReady:     5,
+			clusterScaleUp:   "InProgress",
+			clusterProbeTime: time.Date(2025, 4, 22, 14, 29, 8, 360891242, time.UTC),
+			nodeGroups: []nodeGroup{
+				{
+					name: "MachineDeployment/clusters/workers", health: "Healthy", ready: 3, registered: 4, target: 5, minSize: 3, maxSize: 10,
+					scaleUp: "InProgress", scaleDown: "NoCandidates",
+				},
+				{
+					name: "MachineDeployment/clusters/gpu", health: "Unhealthy", ready: 2, registered: 3, unready: 1, target: 3, minSize: 1,
+					maxSize: 3, scaleUp: "Backoff", scaleDown: "CandidatesPresent", candidates: 1, backoffReason: "QuotaExceeded",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			status, err := Parse(testStatusFixture(t, tt.fixture))
+			require.NoError(t, err)
+			require.Equal(t, tt.format, status.Format)
+			require.True(t, tt.time.Equal(status.Time), status.Time.String())
+			require.Equal(t, tt.autoscalerStatus, status.AutoscalerStatus)
+			require.Equal(t, "Healthy", status.ClusterWide.Health.Status)
+			require.Equal(t, tt.clusterReady, status.ClusterWide.Health.NodeCounts.Registered.Ready)
+			require.Equal(t, tt.clusterScaleUp, status.ClusterWide.ScaleUp.Status)
+			require.True(t, tt.clusterProbeTime.Equal(status.ClusterWide.Health.LastProbeTime))
+			require.Len(t, status.NodeGroups, len(tt.nodeGroups))
+			for i, expected := range tt.nodeGroups {
+				ng := status.NodeGroups[i]
+				require.Equal(t, expected.name, ng.Name)
+				require.Equal(t, expected.health, ng.Health.Status)
+				require.Equal(t, expected.ready, ng.Health.NodeCounts.Registered.Ready)
+				require.Equal(t, expected.registered, ng.Health.NodeCounts.Registered.Total)
+				require.Equal(t, expected.unready, ng.Health.NodeCounts.Registered.Unready.Total)
+				require.Equal(t, expected.target, ng.Health.CloudProviderTarget)
+				require.Equal(t, expected.minSize, ng.Health.MinSize)
+				require.Equal(t, expected.maxSize, ng.Health.MaxSize)
+				require.Equal(t, expected.scaleUp, ng.ScaleUp.Status)
+				require.Equal(t, expected.scaleDown, ng.ScaleDown.Status)
+				require.Equal(t, expected.candidates, ng.ScaleDown.Candidates)
+				require.False(t, ng.Health.LastProbeTime.IsZero())
+				require.False(t, ng.ScaleDown.LastTransitionTime.IsZero())
+				if expected.backoffReason == "" {
+					require.Nil(t, ng.ScaleUp.BackoffInfo)
+				} else {
+					require.NotNil(t, ng.ScaleUp.BackoffInfo)
+					require.Equal(t, expected.backoffReason, ng.ScaleUp.BackoffInfo.ErrorCode)
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":                 "",
+		"legacy invalid time":   "Cluster-autoscaler status at yesterday:\nCluster-wide:\n",
+		"legacy orphaned time":  "Cluster-autoscaler status at 2021-09-14 07:12:45.118734596 +0000 UTC:\n  LastProbeTime: now\n",
+		"yaml invalid time":     "time: yesterday\nnodeGroups: []\n",
+		"yaml invalid document": "nodeGroups: foo\n",
+	}
+	for name, s := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(s)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestHasScaleDownCapacityFixtures(t *testing.T) {
+	status, err := Parse(testStatusFixture(t, "legacy-v1.28.txt"))
+	require.NoError(t, err)
+	node, _ := getNodePoolNameAndNode(t, AWSNodePoolLabelKey, "system")
+	ok, err := HasScaleDownCapacity(status, node, DefaultNodePoolResolver{})
+	require.NoError(t, err)
+	require.False(t, ok)
+	node, _ = getNodePoolNameAndNode(t, AWSNodePoolLabelKey, "user")
+	ok, err = HasScaleDownCapacity(status, node, DefaultNodePoolResolver{})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	status, err = Parse(testStatusFixture(t, "legacy-v1.21.txt"))
+	require.NoError(t, err)
+	node, _ = getNodePoolNameAndNode(t, AzureNodePoolLabelKey, "system")
+	node.Name = "aks-system-31415926-vmss000000"
+	ok, err = HasScaleDownCapacity(status, node, DefaultNodePoolResolver{})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
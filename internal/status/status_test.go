@@ -9,7 +9,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func TestGetNodePoolReadyAndMinCountExisting(t *testing.T) {
+func TestNodeGroupExisting(t *testing.T) {
 	nodePools := []testNodePool{
 		{
 			name:  "foo",
@@ -32,16 +32,17 @@ func TestGetNodePoolReadyAndMinCountExisting(t *testing.T) {
 			min:   35,
 		},
 	}
-	status := mockClusterAutoscalerStatus(t, nodePools)
+	status, err := Parse(mockClusterAutoscalerStatus(t, nodePools))
+	require.NoError(t, err)
 	for _, nodePool := range nodePools {
-		ready, min, err := getNodePoolReadyAndMinCount("v1.31.2", status, nodePool.name)
+		ng, err := status.NodeGroup(nodePool.name)
 		require.NoError(t, err)
-		require.Equal(t, nodePool.ready, ready)
-		require.Equal(t, nodePool.min, min)
+		require.Equal(t, nodePool.ready, ng.Health.NodeCounts.Registered.Ready)
+		require.Equal(t, nodePool.min, ng.Health.MinSize)
 	}
 }
 
-func TestGetNodePoolReadyAndMinCountNotFound(t *testing.T) {
+func TestNodeGroupNotFound(t *testing.T) {
 	nodePools := []testNodePool{
 		{
 			name:  "foo",
@@ -49,8 +50,9 @@ func TestGetNodePoolReadyAndMinCountNotFound(t *testing.T) {
 			min:   22,
 		},
 	}
-	status := mockClusterAutoscalerStatus(t, nodePools)
-	_, _, err := getNodePoolReadyAndMinCount("v1.31.2", status, "bar")
+	status, err := Parse(mockClusterAutoscalerStatus(t, nodePools))
+	require.NoError(t, err)
+	_, err = status.NodeGroup("bar")
 	require.EqualError(t, err, "could not find status for node pool: bar")
 }
 
@@ -80,14 +82,15 @@ func TestHasScaleDownCapacity(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			for _, cp := range getNodePoolLabelKeys() {
-				node, nodePoolName := getNodePoolNameAndNode(t, "v1.31.2", cp, "foobar")
+				node, nodePoolName := getNodePoolNameAndNode(t, cp, "foobar")
 				nodePool := testNodePool{
 					name:  nodePoolName,
 					ready: tt.ready,
 					min:   tt.min,
 				}
-				status := mockClusterAutoscalerStatus(t, []testNodePool{nodePool})
-				ok, err := HasScaleDownCapacity(status, node)
+				status, err := Parse(mockClusterAutoscalerStatus(t, []testNodePool{nodePool}))
+				require.NoError(t, err)
+				ok, err := HasScaleDownCapacity(status, node, DefaultNodePoolResolver{})
 				require.NoError(t, err)
 				require.Equal(t, tt.isSafe, ok)
 			}
@@ -95,6 +98,14 @@ func TestHasScaleDownCapacity(t *testing.T) {
 	}
 }
 
+func TestScaleDownCapacity(t *testing.T) {
+	ng := &NodeGroupsType{Health: HealthType{MinSize: 2, NodeCounts: NodeCountsType{Registered: RegisteredType{Ready: 4, NotStarted: 1}}}}
+	require.Equal(t, 2, ng.ScaleDownCapacity(0))
+	require.Equal(t, 1, ng.ScaleDownCapacity(1))
+	require.Equal(t, -1, ng.ScaleDownCapacity(3))
+	require.True(t, ng.HasScaleDownCapacity())
+}
+
 type testNodePool struct {
 	name  string
 	ready int
@@ -151,7 +162,7 @@ nodeGroups:`
 	return status
 }
 
-func getNodePoolNameAndNode(t *testing.T, version string, cp string, name string) (*corev1.Node, string) {
+func getNodePoolNameAndNode(t *testing.T, cp string, name string) (*corev1.Node, string) {
 	t.Helper()
 
 	switch cp {
@@ -165,11 +176,6 @@ func getNodePoolNameAndNode(t *testing.T, version string, cp string, name string
 					AzureNodePoolLabelKey: name,
 				},
 			},
-			Status: corev1.NodeStatus{
-				NodeInfo: corev1.NodeSystemInfo{
-					KubeletVersion: version,
-				},
-			},
 		}, nodePoolName
 	case AWSNodePoolLabelKey:
 		eksNodePoolName := fmt.Sprintf("dev-eks2-%s", name)
@@ -180,12 +186,7 @@ func getNodePoolNameAndNode(t *testing.T, version string, cp string, name string
 					AWSNodePoolLabelKey: eksNodePoolName,
 				},
 			},
-			Status: corev1.NodeStatus{
-				NodeInfo: corev1.NodeSystemInfo{
-					KubeletVersion: version,
-				},
-			},
-		}, fmt.Sprintf("eks-%s-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}", eksNodePoolName)
+		}, fmt.Sprintf("eks-%s-52c7a2b4-6d2f-4e35-9c1d-0a8b3f5e7d91", eksNodePoolName)
 	case KubemarkNodePoolLabelKey:
 		return &corev1.Node{
 			ObjectMeta: metav1.ObjectMeta{
@@ -194,11 +195,6 @@ func getNodePoolNameAndNode(t *testing.T, version string, cp string, name string
 					KubemarkNodePoolLabelKey: name,
 				},
 			},
-			Status: corev1.NodeStatus{
-				NodeInfo: corev1.NodeSystemInfo{
-					KubeletVersion: version,
-				},
-			},
 		}, name
 	default:
 		t.Fatal("unknown key")
@@ -0,0 +1,213 @@
+package ttl
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxEvictionHistory is the number of evictions kept by the report, older evictions are dropped first.
+const maxEvictionHistory = 100
+
+// NodeStatus is the outcome of the last evaluation of a node with a TTL.
+type NodeStatus struct {
+	Name     string `json:"name"`
+	NodePool string `json:"nodePool,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	// TTL is empty when the expiry is set with the expires at annotation or the TTL is invalid.
+	TTL       string     `json:"ttl,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Expired   bool       `json:"expired"`
+	// Eligible is true when the node is an eviction candidate, it may still wait for the eviction budget.
+	Eligible   bool       `json:"eligible"`
+	SkipReason SkipReason `json:"skipReason,omitempty"`
+	Evicting   bool       `json:"evicting"`
+	Surge      bool       `json:"surge,omitempty"`
+	DrainedAt  *time.Time `json:"drainedAt,omitempty"`
+}
+
+// EvictionState is the state of an eviction.
+type EvictionState string
+
+const (
+	EvictionStateInProgress EvictionState = "InProgress"
+	EvictionStateSucceeded  EvictionState = "Succeeded"
+	EvictionStateFailed     EvictionState = "Failed"
+)
+
+// EvictionRecord describes an eviction started by this instance.
+type EvictionRecord struct {
+	id uint64
+
+	Node        string                `json:"node"`
+	NodePool    string                `json:"nodePool,omitempty"`
+	Policy      string                `json:"policy,omitempty"`
+	Surge       bool                  `json:"surge,omitempty"`
+	State       EvictionState         `json:"state"`
+	StartedAt   time.Time             `json:"startedAt"`
+	CompletedAt *time.Time            `json:"completedAt,omitempty"`
+	Reason      EvictionFailureReason `json:"reason,omitempty"`
+	Error       string                `json:"error,omitempty"`
+}
+
+// NodesResponse is returned by the nodes endpoint.
+type NodesResponse struct {
+	// EvaluatedAt is the time of the last successful evaluation, nil before the first evaluation.
+	EvaluatedAt *time.Time   `json:"evaluatedAt,omitempty"`
+	Nodes       []NodeStatus `json:"nodes"`
+}
+
+// EvictionsResponse is returned by the evictions endpoint.
+type EvictionsResponse struct {
+	Evictions []EvictionRecord `json:"evictions"`
+}
+
+// Report keeps the outcome of the last evaluation and the recent evictions so that they can be inspected through
+// Handler. The zero value is ready to use and a nil Report ignores all updates.
+type Report struct {
+	mu          sync.RWMutex
+	evaluatedAt time.Time
+	nodes       []NodeStatus
+	evictions   []EvictionRecord
+	nextID      uint64
+}
+
+// Nodes returns the status of all nodes with a TTL from the last evaluation.
+func (r *Report) Nodes() NodesResponse {
+	resp := NodesResponse{Nodes: []NodeStatus{}}
+	if r == nil {
+		return resp
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.evaluatedAt.IsZero() {
+		evaluatedAt := r.evaluatedAt
+		resp.EvaluatedAt = &evaluatedAt
+	}
+	resp.Nodes = append(resp.Nodes, r.nodes...)
+	return resp
+}
+
+// Evictions returns the recent evictions ordered by start time.
+func (r *Report) Evictions() EvictionsResponse {
+	resp := EvictionsResponse{Evictions: []EvictionRecord{}}
+	if r == nil {
+		return resp
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resp.Evictions = append(resp.Evictions, r.evictions...)
+	return resp
+}
+
+// Handler serves the report as JSON on /api/v1/nodes and /api/v1/evictions.
+func (r *Report) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/nodes", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Nodes())
+	})
+	mux.HandleFunc("GET /api/v1/evictions", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Evictions())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func (r *Report) recordEvaluation(nodes []NodeStatus, now time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evaluatedAt = now
+	r.nodes = nodes
+}
+
+// recordEvictionStarted adds an eviction in progress and returns its id.
+func (r *Report) recordEvictionStarted(record EvictionRecord) uint64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	record.id = r.nextID
+	record.State = EvictionStateInProgress
+	r.evictions = append(r.evictions, record)
+	if len(r.evictions) > maxEvictionHistory {
+		r.evictions = r.evictions[len(r.evictions)-maxEvictionHistory:]
+	}
+	return record.id
+}
+
+// recordEvictionCompleted sets the outcome of the eviction, evictions which have been dropped from the history are ignored.
+func (r *Report) recordEvictionCompleted(id uint64, err error, now time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.evictions {
+		record := &r.evictions[i]
+		if record.id != id {
+			continue
+		}
+		record.CompletedAt = &now
+		record.State = EvictionStateSucceeded
+		if err != nil {
+			record.State = EvictionStateFailed
+			record.Reason = evictionFailureReason(err)
+			record.Error = err.Error()
+		}
+		return
+	}
+}
+
+// nodeStatuses returns the status of each node with a TTL in the evaluation.
+func nodeStatuses(eval *evaluation, opts Options, inFlight map[string]struct{}, now time.Time) []NodeStatus {
+	candidates := map[string]struct{}{}
+	for _, node := range eval.candidates {
+		candidates[node.Name] = struct{}{}
+	}
+	nodes := []NodeStatus{}
+	for _, node := range eval.nodes {
+		policy := eval.policies[node.Name]
+		nodeStatus := NodeStatus{
+			Name:       node.Name,
+			NodePool:   nodePoolLabel(opts.nodePoolResolver(), node),
+			CreatedAt:  node.CreationTimestamp.Time,
+			SkipReason: eval.skipped[node.Name],
+			Evicting:   evictionInProgress(node, inFlight),
+		}
+		if policy != nil {
+			nodeStatus.Policy = policy.name
+		}
+		if _, ok := node.Annotations[ExpiresAtAnnotationKey]; !ok {
+			if ttl, _, err := nodeTTL(node, policy, opts.TTLRules); err == nil {
+				nodeStatus.TTL = ttl.String()
+			}
+		}
+		if expiry, ok := eval.expiries[node.Name]; ok {
+			nodeStatus.ExpiresAt = &expiry
+			nodeStatus.Expired = !now.Before(expiry)
+		}
+		_, nodeStatus.Eligible = candidates[node.Name]
+		_, nodeStatus.Surge = eval.surge[node.Name]
+		if drained, ok := drainedAt(node); ok {
+			nodeStatus.DrainedAt = &drained
+		}
+		nodes = append(nodes, nodeStatus)
+	}
+	return nodes
+}
@@ -0,0 +1,122 @@
+package ttl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+func TestNodeStatuses(t *testing.T) {
+	expiredOffset := -10 * time.Minute
+	expired := testNodeWithTTL("expired", &expiredOffset, 1*time.Minute, false)
+	expired.Labels[status.KubemarkNodePoolLabelKey] = "pool"
+	disabled := testNodeWithTTL("disabled", &expiredOffset, 1*time.Minute, false)
+	disabled.Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+	validOffset := -30 * time.Minute
+	valid := testNodeWithTTL("valid", &validOffset, 1*time.Hour, false)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(expired, disabled, valid)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	nodes := nodeStatuses(eval, Options{}, map[string]struct{}{"expired": {}}, time.Now())
+	require.Len(t, nodes, 3)
+
+	require.Equal(t, "disabled", nodes[0].Name)
+	require.True(t, nodes[0].Expired)
+	require.False(t, nodes[0].Eligible)
+	require.Equal(t, SkipReasonScaleDownDisabled, nodes[0].SkipReason)
+
+	require.Equal(t, "expired", nodes[1].Name)
+	require.Equal(t, "pool", nodes[1].NodePool)
+	require.Equal(t, "1m0s", nodes[1].TTL)
+	require.True(t, nodes[1].Expired)
+	require.True(t, nodes[1].Eligible)
+	require.True(t, nodes[1].Evicting)
+	require.Empty(t, nodes[1].SkipReason)
+
+	require.Equal(t, "valid", nodes[2].Name)
+	require.False(t, nodes[2].Expired)
+	require.False(t, nodes[2].Eligible)
+	require.NotNil(t, nodes[2].ExpiresAt)
+	require.WithinDuration(t, time.Now().Add(30*time.Minute), *nodes[2].ExpiresAt, time.Second)
+}
+
+func TestReportEvictions(t *testing.T) {
+	report := &Report{}
+	first := report.recordEvictionStarted(EvictionRecord{Node: "first", StartedAt: time.Now()})
+	second := report.recordEvictionStarted(EvictionRecord{Node: "second", StartedAt: time.Now()})
+	report.recordEvictionCompleted(first, nil, time.Now())
+	report.recordEvictionCompleted(second, newEvictionError(EvictionFailureReasonCordon, errors.New("conflict")), time.Now())
+	third := report.recordEvictionStarted(EvictionRecord{Node: "third", StartedAt: time.Now()})
+
+	evictions := report.Evictions().Evictions
+	require.Len(t, evictions, 3)
+	require.Equal(t, EvictionStateSucceeded, evictions[0].State)
+	require.NotNil(t, evictions[0].CompletedAt)
+	require.Equal(t, EvictionStateFailed, evictions[1].State)
+	require.Equal(t, EvictionFailureReasonCordon, evictions[1].Reason)
+	require.Equal(t, "conflict", evictions[1].Error)
+	require.Equal(t, EvictionStateInProgress, evictions[2].State)
+	require.Nil(t, evictions[2].CompletedAt)
+
+	// Only the most recent evictions are kept.
+	for range maxEvictionHistory {
+		report.recordEvictionStarted(EvictionRecord{Node: "other", StartedAt: time.Now()})
+	}
+	report.recordEvictionCompleted(third, nil, time.Now())
+	evictions = report.Evictions().Evictions
+	require.Len(t, evictions, maxEvictionHistory)
+	for _, eviction := range evictions {
+		require.Equal(t, "other", eviction.Node)
+	}
+
+	// A nil report ignores updates.
+	var nilReport *Report
+	nilReport.recordEvictionCompleted(nilReport.recordEvictionStarted(EvictionRecord{Node: "node"}), nil, time.Now())
+	require.Empty(t, nilReport.Evictions().Evictions)
+}
+
+func TestReportHandler(t *testing.T) {
+	report := &Report{}
+	handler := report.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/nodes", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"nodes":[]}`, rec.Body.String())
+
+	now := time.Now()
+	report.recordEvaluation([]NodeStatus{{Name: "node", Expired: true, SkipReason: SkipReasonNotSafeToEvictPods}}, now)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/nodes", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	resp := NodesResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotNil(t, resp.EvaluatedAt)
+	require.True(t, now.Equal(*resp.EvaluatedAt))
+	require.Equal(t, SkipReasonNotSafeToEvictPods, resp.Nodes[0].SkipReason)
+
+	report.recordEvictionStarted(EvictionRecord{Node: "node", StartedAt: now})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/evictions", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	evictions := EvictionsResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &evictions))
+	require.Len(t, evictions.Evictions, 1)
+	require.Equal(t, EvictionStateInProgress, evictions.Evictions[0].State)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/nodes", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
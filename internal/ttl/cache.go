@@ -0,0 +1,179 @@
+package ttl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/xenitab/node-ttl/internal/api/v1alpha1"
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+const podNodeNameIndex = "spec.nodeName"
+
+// nodeCache contains informer backed listers for all resources read when evaluating nodes.
+type nodeCache struct {
+	nodes      corelisters.NodeLister
+	pods       cache.Indexer
+	configMaps corelisters.ConfigMapLister
+	policies   cache.Indexer
+
+	informers []cache.SharedIndexInformer
+}
+
+// newNodeCache starts informers for nodes, pods and the cluster autoscaler status and waits for them to sync.
+// Only nodes matching the label selector are cached, all nodes are cached if the selector is empty.
+func newNodeCache(ctx context.Context, client kubernetes.Interface, nodeSelector string,
+	clusterAutoscalerStatus *types.NamespacedName) (*nodeCache, error) {
+	factory := informers.NewSharedInformerFactory(client, 0)
+
+	nodeInformer := factory.InformerFor(&corev1.Node{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		tweak := func(opts *metav1.ListOptions) {
+			opts.LabelSelector = nodeSelector
+		}
+		return coreinformers.NewFilteredNodeInformer(client, resync, cache.Indexers{}, tweak)
+	})
+
+	podInformer := factory.Core().V1().Pods().Informer()
+	err := podInformer.AddIndexers(cache.Indexers{podNodeNameIndex: podNodeNameIndexFunc})
+	if err != nil {
+		return nil, err
+	}
+	err = podInformer.SetTransform(stripManagedFields)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &nodeCache{
+		nodes:     corelisters.NewNodeLister(nodeInformer.GetIndexer()),
+		pods:      podInformer.GetIndexer(),
+		informers: []cache.SharedIndexInformer{nodeInformer, podInformer},
+	}
+	synced := []cache.InformerSynced{nodeInformer.HasSynced, podInformer.HasSynced}
+	if clusterAutoscalerStatus != nil {
+		newInformer := func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+			tweak := func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", clusterAutoscalerStatus.Name).String()
+			}
+			return coreinformers.NewFilteredConfigMapInformer(client, clusterAutoscalerStatus.Namespace, resync, cache.Indexers{}, tweak)
+		}
+		configMapInformer := factory.InformerFor(&corev1.ConfigMap{}, newInformer)
+		c.configMaps = corelisters.NewConfigMapLister(configMapInformer.GetIndexer())
+		c.informers = append(c.informers, configMapInformer)
+		synced = append(synced, configMapInformer.HasSynced)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return nil, errors.New("timed out waiting for caches to sync")
+	}
+	return c, nil
+}
+
+// watchPolicies starts an informer for NodeTTLPolicies and waits for it to sync.
+func (c *nodeCache) watchPolicies(ctx context.Context, client dynamic.Interface) error {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	informer := factory.ForResource(v1alpha1.NodeTTLPolicyResource).Informer()
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return errors.New("timed out waiting for node ttl policy cache to sync")
+	}
+	c.policies = informer.GetIndexer()
+	c.informers = append(c.informers, informer)
+	return nil
+}
+
+// addEventHandler registers the handler with all informers. The handler will receive objects of any of the cached types.
+func (c *nodeCache) addEventHandler(handler cache.ResourceEventHandler) error {
+	for _, informer := range c.informers {
+		_, err := informer.AddEventHandler(handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podsOnNode returns all Pods scheduled to the node.
+func (c *nodeCache) podsOnNode(nodeName string) ([]*corev1.Pod, error) {
+	objs, err := c.pods.ByIndex(podNodeNameIndex, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*corev1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object type in pod cache: %T", obj)
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// unscheduledPods returns all Pods which have not been scheduled to a node.
+func (c *nodeCache) unscheduledPods() ([]*corev1.Pod, error) {
+	return c.podsOnNode("")
+}
+
+// nodeTTLPolicies returns all cached NodeTTLPolicies.
+func (c *nodeCache) nodeTTLPolicies() ([]*unstructured.Unstructured, error) {
+	objs := c.policies.List()
+	policies := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		policy, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object type in policy cache: %T", obj)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// clusterAutoscalerStatus returns the parsed status of the cluster autoscaler status config map.
+func (c *nodeCache) clusterAutoscalerStatus(nn types.NamespacedName) (*status.ClusterAutoscalerStatus, error) {
+	caConfigMap, err := c.configMaps.ConfigMaps(nn.Namespace).Get(nn.Name)
+	if err != nil {
+		return nil, err
+	}
+	caStatus, ok := caConfigMap.Data["status"]
+	if !ok {
+		return nil, fmt.Errorf("could not find status in config map")
+	}
+	return status.Parse(caStatus)
+}
+
+func podNodeNameIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	// Unscheduled Pods are indexed with an empty node name.
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// stripManagedFields removes managed fields to lower the memory footprint of the pod cache.
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		//nolint:nilerr // tombstones do not have object meta and are returned as is
+		return obj, nil
+	}
+	accessor.SetManagedFields(nil)
+	return obj, nil
+}
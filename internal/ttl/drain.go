@@ -0,0 +1,513 @@
+package ttl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	k8sretry "k8s.io/client-go/util/retry"
+	"k8s.io/kubectl/pkg/drain"
+
+	"github.com/xenitab/node-ttl/internal/duration"
+)
+
+var drainBlockedPods = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_ttl_drain_blocked_pods",
+	Help: "Number of Pods which cannot be evicted from a draining node because of a Pod Disruption Budget.",
+}, []string{"node"})
+
+// DrainTimeoutPolicy decides what happens to a node which has not been drained before the drain timeout.
+type DrainTimeoutPolicy string
+
+const (
+	// DrainTimeoutPolicyUncordon gives up the eviction, uncordons the node and backs off before evicting it again.
+	DrainTimeoutPolicyUncordon DrainTimeoutPolicy = "Uncordon"
+	// DrainTimeoutPolicyDeletePods deletes the remaining Pods without respecting Pod Disruption Budgets.
+	DrainTimeoutPolicyDeletePods DrainTimeoutPolicy = "DeletePods"
+)
+
+var errDrainTimeout = errors.New("drain did not complete before the drain timeout")
+
+// errPodsNotDeletable is returned when the drain options do not allow some of the Pods on a node to be deleted, for
+// example Pods without a controller when force is disabled.
+var errPodsNotDeletable = errors.New("pods cannot be deleted with the drain options")
+
+// drainCheckInterval is how often a drain which has not completed is checked for blocking Pods.
+var drainCheckInterval = 1 * time.Minute
+
+// maxReportedBlockedPods limits the number of Pods listed in a single event.
+const maxReportedBlockedPods = 10
+
+// blockedPod is a Pod which cannot be evicted as its Pod Disruption Budget does not allow any disruptions.
+type blockedPod struct {
+	namespace           string
+	name                string
+	podDisruptionBudget string
+}
+
+func (b blockedPod) String() string {
+	return fmt.Sprintf("%s/%s (%s)", b.namespace, b.name, b.podDisruptionBudget)
+}
+
+// drainOptions configures how a node is drained.
+type drainOptions struct {
+	timeout                         time.Duration
+	timeoutPolicy                   DrainTimeoutPolicy
+	backoff                         time.Duration
+	force                           bool
+	gracePeriodSeconds              int
+	deleteEmptyDirData              bool
+	podSelector                     string
+	skipWaitForDeleteTimeoutSeconds int
+	disableEviction                 bool
+}
+
+// nodeDrainOptions returns the global drain options with the overrides of the policy and the node annotations applied.
+func nodeDrainOptions(opts Options, policy *nodePolicy, node *corev1.Node) (drainOptions, error) {
+	drainOpts := drainOptions{
+		timeout:                         opts.DrainTimeout,
+		timeoutPolicy:                   opts.DrainTimeoutPolicy,
+		backoff:                         opts.DrainBackoff,
+		force:                           opts.DrainForce,
+		gracePeriodSeconds:              opts.DrainGracePeriodSeconds,
+		deleteEmptyDirData:              opts.DrainDeleteEmptyDirData,
+		podSelector:                     opts.DrainPodSelector,
+		skipWaitForDeleteTimeoutSeconds: opts.DrainSkipWaitForDeleteTimeoutSeconds,
+		disableEviction:                 opts.DrainDisableEviction,
+	}
+	if policy != nil {
+		if policy.drain.timeout != nil {
+			drainOpts.timeout = *policy.drain.timeout
+		}
+		if policy.drain.timeoutPolicy != nil {
+			drainOpts.timeoutPolicy = *policy.drain.timeoutPolicy
+		}
+		if policy.drain.backoff != nil {
+			drainOpts.backoff = *policy.drain.backoff
+		}
+		if policy.drain.force != nil {
+			drainOpts.force = *policy.drain.force
+		}
+		if policy.drain.gracePeriodSeconds != nil {
+			drainOpts.gracePeriodSeconds = *policy.drain.gracePeriodSeconds
+		}
+		if policy.drain.deleteEmptyDirData != nil {
+			drainOpts.deleteEmptyDirData = *policy.drain.deleteEmptyDirData
+		}
+		if policy.drain.podSelector != nil {
+			drainOpts.podSelector = *policy.drain.podSelector
+		}
+		if policy.drain.skipWaitForDeleteTimeoutSeconds != nil {
+			drainOpts.skipWaitForDeleteTimeoutSeconds = *policy.drain.skipWaitForDeleteTimeoutSeconds
+		}
+		if policy.drain.disableEviction != nil {
+			drainOpts.disableEviction = *policy.drain.disableEviction
+		}
+	}
+	if node != nil {
+		err := applyDrainAnnotations(node, &drainOpts)
+		if err != nil {
+			return drainOptions{}, err
+		}
+	}
+	if err := drainOpts.validate(); err != nil {
+		return drainOptions{}, err
+	}
+	return drainOpts, nil
+}
+
+// applyDrainAnnotations overrides the drain options with the values of the drain annotations of the node.
+func applyDrainAnnotations(node *corev1.Node, drainOpts *drainOptions) error {
+	errs := []error{
+		boolAnnotation(node, DrainForceAnnotationKey, &drainOpts.force),
+		intAnnotation(node, DrainGracePeriodSecondsAnnotationKey, &drainOpts.gracePeriodSeconds),
+		boolAnnotation(node, DrainDeleteEmptyDirDataAnnotationKey, &drainOpts.deleteEmptyDirData),
+		intAnnotation(node, DrainSkipWaitForDeleteTimeoutSecondsAnnotationKey, &drainOpts.skipWaitForDeleteTimeoutSeconds),
+		boolAnnotation(node, DrainDisableEvictionAnnotationKey, &drainOpts.disableEviction),
+	}
+	if value, ok := node.Annotations[DrainTimeoutAnnotationKey]; ok {
+		timeout, err := duration.Parse(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s annotation: %w", DrainTimeoutAnnotationKey, err))
+		}
+		drainOpts.timeout = timeout
+	}
+	if value, ok := node.Annotations[DrainPodSelectorAnnotationKey]; ok {
+		drainOpts.podSelector = value
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func boolAnnotation(node *corev1.Node, key string, target *bool) error {
+	value, ok := node.Annotations[key]
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation: %w", key, err)
+	}
+	*target = b
+	return nil
+}
+
+func intAnnotation(node *corev1.Node, key string, target *int) error {
+	value, ok := node.Annotations[key]
+	if !ok {
+		return nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation: %w", key, err)
+	}
+	*target = i
+	return nil
+}
+
+// validate returns an error if the drain options cannot be used to drain a node.
+func (d drainOptions) validate() error {
+	if d.timeout < 0 {
+		return errors.New("drain timeout cannot be negative")
+	}
+	if d.skipWaitForDeleteTimeoutSeconds < 0 {
+		return errors.New("drain skip wait for delete timeout cannot be negative")
+	}
+	if _, err := labels.Parse(d.podSelector); err != nil {
+		return fmt.Errorf("invalid drain pod selector: %w", err)
+	}
+	return nil
+}
+
+// evict cordons and drains a node which has an expired TTL, after which the node is terminated if a terminator is set.
+// When surge is set a new node is added to the node pool before the node is cordoned.
+func (c *controller) evict(ctx context.Context, node *corev1.Node, drainOpts drainOptions, surge bool) error {
+	log := logr.FromContextOrDiscard(ctx)
+	// A node which has already been drained only has to be terminated.
+	if _, ok := drainedAt(node); ok {
+		return c.terminate(ctx, node)
+	}
+	if surge {
+		err := c.surge(ctx, node)
+		if err != nil {
+			return newEvictionError(EvictionFailureReasonSurge, err)
+		}
+		// The placeholder Pod keeps the new node from being scaled down before the drained Pods are scheduled to it.
+		defer c.deleteSurgePod(context.WithoutCancel(ctx), node)
+	}
+	log.Info("evicting node with expired ttl", "node", node.Name)
+	nodePool := nodePoolLabel(c.opts.nodePoolResolver(), node)
+	helper := newDrainHelper(ctx, c.client, drainOpts, nodePool)
+	started, err := cordonNode(ctx, c.client, helper, node, c.opts.Identity)
+	if err != nil {
+		return newEvictionError(EvictionFailureReasonCordon, err)
+	}
+	err = c.drainNode(ctx, helper, node, started, drainOpts)
+	if errors.Is(err, errDrainTimeout) {
+		return newEvictionError(EvictionFailureReasonDrainTimeout, err)
+	}
+	if err != nil {
+		return newEvictionError(EvictionFailureReasonDrain, err)
+	}
+	log.Info("eviction complete", "node", node.Name)
+	evictedNodesTotal.Inc()
+	lastEvictionTimeSeconds.Set(float64(time.Now().Unix()))
+	drainDurationSeconds.WithLabelValues(nodePool).Observe(time.Since(started).Seconds())
+	err = markDrained(ctx, c.client, node.Name)
+	if err != nil {
+		return newEvictionError(EvictionFailureReasonMarkDrained, fmt.Errorf("could not mark node %s as drained: %w", node.Name, err))
+	}
+	return c.terminate(ctx, node)
+}
+
+// terminate removes the drained node with the terminator, the node is left to cluster autoscaler if none is set.
+// A failed termination is retried when the eviction is resumed by a later evaluation, while a node which has been
+// terminated is marked so that it is not terminated again while it is being removed.
+func (c *controller) terminate(ctx context.Context, node *corev1.Node) error {
+	if c.opts.Terminator == nil {
+		return nil
+	}
+	err := c.opts.Terminator.Terminate(ctx, node)
+	if err != nil {
+		return newEvictionError(EvictionFailureReasonTerminate, fmt.Errorf("could not terminate node %s: %w", node.Name, err))
+	}
+	err = markTerminated(ctx, c.client, node.Name)
+	if err != nil {
+		return newEvictionError(EvictionFailureReasonTerminate, fmt.Errorf("could not mark node %s as terminated: %w", node.Name, err))
+	}
+	logr.FromContextOrDiscard(ctx).Info("terminated drained node", "node", node.Name)
+	c.recorder.Event(node, corev1.EventTypeNormal, EventReasonTTLNodeTerminated, "Drained node has been terminated")
+	return nil
+}
+
+func newDrainHelper(ctx context.Context, client kubernetes.Interface, drainOpts drainOptions, nodePool string) *drain.Helper {
+	log := logr.FromContextOrDiscard(ctx)
+	return &drain.Helper{
+		Ctx:                             ctx,
+		Client:                          client,
+		Force:                           drainOpts.force,
+		GracePeriodSeconds:              drainOpts.gracePeriodSeconds,
+		IgnoreAllDaemonSets:             true,
+		DeleteEmptyDirData:              drainOpts.deleteEmptyDirData,
+		PodSelector:                     drainOpts.podSelector,
+		SkipWaitForDeleteTimeoutSeconds: drainOpts.skipWaitForDeleteTimeoutSeconds,
+		DisableEviction:                 drainOpts.disableEviction,
+		ErrOut:                          io.Discard,
+		Out:                             io.Discard,
+		OnPodDeletedOrEvicted: func(pod *corev1.Pod, usingEviction bool) {
+			log.Info("completed eviction", "pod", pod.Name)
+			evictedPodsTotal.WithLabelValues(nodePool).Inc()
+		},
+	}
+}
+
+// cordonNode marks the eviction as owned by node ttl and cordons the node. The time at which the eviction first
+// started is returned.
+func cordonNode(ctx context.Context, client kubernetes.Interface, helper *drain.Helper, node *corev1.Node,
+	identity string) (time.Time, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	var started time.Time
+	// Retry to avoid large delays when API server hickups occur.
+	err := retry.Do(func() error {
+		var err error
+		started, err = markEviction(ctx, client, node.Name, identity)
+		if err != nil {
+			return fmt.Errorf("could not mark node %s: %w", node.Name, err)
+		}
+		err = drain.RunCordonOrUncordon(helper, node, true)
+		if err != nil {
+			return fmt.Errorf("could not cordon node %s: %w", node.Name, err)
+		}
+		return nil
+	}, retry.OnRetry(func(n uint, err error) {
+		log.Error(err, "retrying cordon due to error", "attempt", n)
+	}), retry.Attempts(5), retry.Delay(1*time.Second))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return started, nil
+}
+
+// drainNode drains the node until all Pods are removed. Pods blocking the drain are reported every check interval, only
+// the first failed attempt is logged as an error as the event and metric show that the drain is still blocked.
+// When the drain timeout, measured from when the eviction first started, is reached the drain timeout policy is applied.
+func (c *controller) drainNode(ctx context.Context, helper *drain.Helper, node *corev1.Node, started time.Time,
+	drainOpts drainOptions) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("node", node.Name)
+	defer drainBlockedPods.DeleteLabelValues(node.Name)
+	timedOut := false
+	logged := false
+	for {
+		attemptStarted := time.Now()
+		helper.Timeout = drainCheckInterval
+		if drainOpts.timeout > 0 && !timedOut {
+			remaining := time.Until(started.Add(drainOpts.timeout))
+			if remaining <= 0 {
+				err := c.handleDrainTimeout(ctx, helper, node, drainOpts)
+				if err != nil {
+					return err
+				}
+				timedOut = true
+				continue
+			}
+			helper.Timeout = min(helper.Timeout, remaining)
+		}
+		err := drain.RunNodeDrain(helper, node.Name)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if logged {
+			log.V(1).Info("drain has not completed", "error", err.Error())
+		} else {
+			log.Error(err, "drain has not completed")
+			logged = true
+		}
+		c.reportBlockedPods(ctx, helper, node)
+		// Wait for the remainder of the interval in case the drain failed early.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(attemptStarted.Add(drainCheckInterval))):
+		}
+	}
+}
+
+// handleDrainTimeout applies the drain timeout policy. An error is returned when the eviction is given up.
+func (c *controller) handleDrainTimeout(ctx context.Context, helper *drain.Helper, node *corev1.Node, drainOpts drainOptions) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("node", node.Name)
+	switch drainOpts.timeoutPolicy {
+	case DrainTimeoutPolicyDeletePods:
+		log.Info("drain timeout reached, deleting pods without respecting pod disruption budgets")
+		c.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonTTLDrainTimeout,
+			"Drain did not complete within %s, deleting remaining Pods without respecting Pod Disruption Budgets", drainOpts.timeout)
+		helper.DisableEviction = true
+		return nil
+	default:
+		until := time.Now().Add(drainOpts.backoff)
+		log.Info("drain timeout reached, uncordoning node", "until", until)
+		c.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonTTLDrainTimeout,
+			"Drain did not complete within %s, uncordoning node until %s", drainOpts.timeout, until.UTC().Format(time.RFC3339))
+		err := abandonEviction(ctx, c.client, node.Name, until)
+		if err != nil {
+			return fmt.Errorf("could not uncordon node %s after drain timeout: %w", node.Name, err)
+		}
+		return fmt.Errorf("%w of %s for node %s", errDrainTimeout, drainOpts.timeout, node.Name)
+	}
+}
+
+// reportBlockedPods exposes the Pods which are blocking the drain of the node.
+func (c *controller) reportBlockedPods(ctx context.Context, helper *drain.Helper, node *corev1.Node) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("node", node.Name)
+	blocked, err := blockingPods(ctx, c.client, helper, node.Name)
+	if errors.Is(err, errPodsNotDeletable) {
+		log.Error(err, "drain is blocked by pods which cannot be deleted")
+		c.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonTTLDrainBlocked, "Drain is blocked: %v", err)
+		return
+	}
+	if err != nil {
+		log.Error(err, "could not determine pods blocking drain")
+		return
+	}
+	drainBlockedPods.WithLabelValues(node.Name).Set(float64(len(blocked)))
+	if len(blocked) == 0 {
+		return
+	}
+	names := []string{}
+	for _, pod := range blocked {
+		names = append(names, pod.String())
+	}
+	log.Info("drain is blocked by pod disruption budgets", "pods", names)
+	if len(names) > maxReportedBlockedPods {
+		names = append(names[:maxReportedBlockedPods], fmt.Sprintf("and %d more", len(names)-maxReportedBlockedPods))
+	}
+	c.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonTTLDrainBlocked,
+		"Drain is blocked by Pod Disruption Budgets: %s", strings.Join(names, ", "))
+}
+
+// blockingPods returns the Pods remaining on the node which are covered by a Pod Disruption Budget that does not
+// allow any disruptions.
+func blockingPods(ctx context.Context, client kubernetes.Interface, helper *drain.Helper, nodeName string) ([]blockedPod, error) {
+	podList, errs := helper.GetPodsForDeletion(nodeName)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", errPodsNotDeletable, utilerrors.NewAggregate(errs))
+	}
+	pdbsByNamespace := map[string][]policyv1.PodDisruptionBudget{}
+	blocked := []blockedPod{}
+	for _, pod := range podList.Pods() {
+		// Terminating Pods have already been evicted.
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		pdbs, ok := pdbsByNamespace[pod.Namespace]
+		if !ok {
+			pdbList, err := client.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			pdbs = pdbList.Items
+			pdbsByNamespace[pod.Namespace] = pdbs
+		}
+		for _, pdb := range pdbs {
+			if pdb.Status.DisruptionsAllowed > 0 {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil {
+				continue
+			}
+			if !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			blocked = append(blocked, blockedPod{namespace: pod.Namespace, name: pod.Name, podDisruptionBudget: pdb.Name})
+			break
+		}
+	}
+	return blocked, nil
+}
+
+// markEviction annotates and taints the node to record that the eviction is owned by node ttl. A node which is already
+// marked keeps its original start time so that a resumed eviction is still attributed to the first attempt.
+func markEviction(ctx context.Context, client kubernetes.Interface, nodeName, identity string) (time.Time, error) {
+	var started time.Time
+	err := k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		started, err = time.Parse(time.RFC3339, node.Annotations[EvictionStartedAnnotationKey])
+		if err != nil {
+			started = time.Now().UTC().Truncate(time.Second)
+			node.Annotations[EvictionStartedAnnotationKey] = started.Format(time.RFC3339)
+		}
+		node.Annotations[EvictionOwnerAnnotationKey] = identity
+		delete(node.Annotations, EvictionBackoffAnnotationKey)
+		if !hasEvictionTaint(node) {
+			node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+				Key:    EvictionTaintKey,
+				Effect: corev1.TaintEffectNoSchedule,
+			})
+		}
+		_, err = client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return started, nil
+}
+
+// abandonEviction removes the eviction annotations and taint, uncordons the node and records when the node may be
+// evicted again.
+func abandonEviction(ctx context.Context, client kubernetes.Interface, nodeName string, until time.Time) error {
+	return k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		delete(node.Annotations, EvictionStartedAnnotationKey)
+		delete(node.Annotations, EvictionOwnerAnnotationKey)
+		node.Annotations[EvictionBackoffAnnotationKey] = until.UTC().Format(time.RFC3339)
+		taints := []corev1.Taint{}
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == EvictionTaintKey {
+				continue
+			}
+			taints = append(taints, taint)
+		}
+		node.Spec.Taints = taints
+		node.Spec.Unschedulable = false
+		_, err = client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func hasEvictionTaint(node *corev1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == EvictionTaintKey {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package ttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMarkEviction(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, false)
+	node.Annotations = map[string]string{EvictionBackoffAnnotationKey: time.Now().Format(time.RFC3339)}
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	started, err := markEviction(ctx, client, "node", "first")
+	require.NoError(t, err)
+	marked, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.True(t, evictionInProgress(marked, nil))
+	require.Equal(t, "first", marked.Annotations[EvictionOwnerAnnotationKey])
+	require.NotContains(t, marked.Annotations, EvictionBackoffAnnotationKey)
+	require.Len(t, marked.Spec.Taints, 1)
+
+	// Resuming the eviction keeps the start time and does not add the taint twice.
+	resumedStarted, err := markEviction(ctx, client, "node", "second")
+	require.NoError(t, err)
+	require.True(t, started.Equal(resumedStarted))
+	resumed, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, marked.Annotations[EvictionStartedAnnotationKey], resumed.Annotations[EvictionStartedAnnotationKey])
+	require.Equal(t, "second", resumed.Annotations[EvictionOwnerAnnotationKey])
+	require.Len(t, resumed.Spec.Taints, 1)
+}
+
+func TestAbandonEviction(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, true)
+	node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{Key: "other", Effect: corev1.TaintEffectNoSchedule})
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err := abandonEviction(ctx, client, "node", until)
+	require.NoError(t, err)
+	abandoned, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, abandoned.Spec.Unschedulable)
+	require.False(t, evictionInProgress(abandoned, nil))
+	require.Equal(t, []corev1.Taint{{Key: "other", Effect: corev1.TaintEffectNoSchedule}}, abandoned.Spec.Taints)
+	backoff, ok := evictionBackoff(ctx, abandoned)
+	require.True(t, ok)
+	require.True(t, until.Equal(backoff))
+}
+
+func TestBlockingPods(t *testing.T) {
+	pods := []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "blocked", Namespace: "default", Labels: map[string]string{"app": "blocked"}},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allowed", Namespace: "default", Labels: map[string]string{"app": "allowed"}},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "unprotected", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		},
+	}
+	pdbs := []*policyv1.PodDisruptionBudget{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "blocking", Namespace: "default"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "blocked"}}},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 0},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allowing", Namespace: "default"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "allowed"}}},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+		},
+	}
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		_, err := client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	for _, pdb := range pdbs {
+		_, err := client.PolicyV1().PodDisruptionBudgets(pdb.Namespace).Create(ctx, pdb, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	drainOpts := drainOptions{force: true, gracePeriodSeconds: -1, deleteEmptyDirData: true}
+	blocked, err := blockingPods(ctx, client, newDrainHelper(ctx, client, drainOpts, ""), "node")
+	require.NoError(t, err)
+	require.Equal(t, []blockedPod{{namespace: "default", name: "blocked", podDisruptionBudget: "blocking"}}, blocked)
+
+	// Pods without a controller block the drain when force is disabled.
+	drainOpts.force = false
+	_, err = blockingPods(ctx, client, newDrainHelper(ctx, client, drainOpts, ""), "node")
+	require.ErrorIs(t, err, errPodsNotDeletable)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, true)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	recorder := record.NewFakeRecorder(10)
+	c := &controller{
+		client:   client,
+		opts:     Options{DrainTimeout: time.Minute, DrainTimeoutPolicy: DrainTimeoutPolicyUncordon, DrainBackoff: time.Hour},
+		recorder: recorder,
+	}
+	drainOpts, err := nodeDrainOptions(c.opts, nil, node)
+	require.NoError(t, err)
+	err = c.drainNode(ctx, newDrainHelper(ctx, client, drainOpts, ""), node, time.Now().Add(-time.Hour), drainOpts)
+	require.ErrorIs(t, err, errDrainTimeout)
+	require.Len(t, recorder.Events, 1)
+	abandoned, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, abandoned.Spec.Unschedulable)
+	_, ok := evictionBackoff(ctx, abandoned)
+	require.True(t, ok)
+}
+
+func TestNodeDrainOptions(t *testing.T) {
+	opts := Options{
+		DrainTimeout:            time.Hour,
+		DrainForce:              true,
+		DrainGracePeriodSeconds: -1,
+		DrainDeleteEmptyDirData: true,
+	}
+	policyTimeout := 2 * time.Hour
+	policy := &nodePolicy{drain: drainOverrides{timeout: &policyTimeout}}
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, false)
+
+	drainOpts, err := nodeDrainOptions(opts, policy, node)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, drainOpts.timeout)
+	require.True(t, drainOpts.force)
+
+	// Node annotations override both the global options and the policy.
+	node.Annotations = map[string]string{
+		DrainForceAnnotationKey:                           "false",
+		DrainGracePeriodSecondsAnnotationKey:              "30",
+		DrainDeleteEmptyDirDataAnnotationKey:              "false",
+		DrainTimeoutAnnotationKey:                         "1d",
+		DrainPodSelectorAnnotationKey:                     "app!=critical",
+		DrainSkipWaitForDeleteTimeoutSecondsAnnotationKey: "60",
+		DrainDisableEvictionAnnotationKey:                 "true",
+	}
+	drainOpts, err = nodeDrainOptions(opts, policy, node)
+	require.NoError(t, err)
+	require.Equal(t, drainOptions{
+		timeout:                         24 * time.Hour,
+		force:                           false,
+		gracePeriodSeconds:              30,
+		deleteEmptyDirData:              false,
+		podSelector:                     "app!=critical",
+		skipWaitForDeleteTimeoutSeconds: 60,
+		disableEviction:                 true,
+	}, drainOpts)
+
+	invalid := map[string]string{
+		DrainForceAnnotationKey:                           "maybe",
+		DrainGracePeriodSecondsAnnotationKey:              "30s",
+		DrainTimeoutAnnotationKey:                         "-1h",
+		DrainPodSelectorAnnotationKey:                     "=critical",
+		DrainSkipWaitForDeleteTimeoutSecondsAnnotationKey: "-1",
+	}
+	for key, value := range invalid {
+		t.Run(key, func(t *testing.T) {
+			node.Annotations = map[string]string{key: value}
+			_, err := nodeDrainOptions(opts, policy, node)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package ttl
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	EventReasonTTLExpired           = "TTLExpired"
+	EventReasonTTLEvictionStarted   = "TTLEvictionStarted"
+	EventReasonTTLEvictionSkipped   = "TTLEvictionSkipped"
+	EventReasonTTLEvictionFailed    = "TTLEvictionFailed"
+	EventReasonTTLEvictionCompleted = "TTLEvictionCompleted"
+	EventReasonTTLDrainBlocked      = "TTLDrainBlocked"
+	EventReasonTTLDrainTimeout      = "TTLDrainTimeout"
+	EventReasonTTLSurge             = "TTLSurge"
+	EventReasonTTLNodeTerminated    = "TTLNodeTerminated"
+	EventReasonTTLRemovalTimeout    = "TTLRemovalTimeout"
+)
+
+const eventComponent = "node-ttl"
+
+// newEventRecorder returns a recorder which emits events to the API server until the context is cancelled.
+func newEventRecorder(ctx context.Context, client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	go func() {
+		<-ctx.Done()
+		broadcaster.Shutdown()
+	}()
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// recordSkipped emits an event for each node with a skip reason which differs from the previous evaluation.
+func (c *controller) recordSkipped(eval *evaluation) {
+	for nodeName, reason := range eval.skipped {
+		if c.skipped[nodeName] == reason {
+			continue
+		}
+		node, err := c.nodeCache.nodes.Get(nodeName)
+		if err != nil {
+			continue
+		}
+		c.recorder.Event(node, corev1.EventTypeNormal, EventReasonTTLEvictionSkipped, fmt.Sprintf("Skipping TTL eviction: %s", reason))
+	}
+}
+
+// recordExpired emits an event the first time a node is observed to have an expired TTL.
+func (c *controller) recordExpired(eval *evaluation) {
+	expired := map[string]struct{}{}
+	for _, node := range eval.expired {
+		expired[node.Name] = struct{}{}
+		if _, ok := c.expired[node.Name]; ok {
+			continue
+		}
+		c.recorder.Event(node, corev1.EventTypeNormal, EventReasonTTLExpired, "Node TTL has expired")
+	}
+	c.expired = expired
+}
@@ -0,0 +1,36 @@
+package ttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecordEvents(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("scale-down-disabled", &creationOffset, 1*time.Minute, false)
+	node.Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(node)
+	c, err := newController(ctx, client, Options{DryRun: true, MaxConcurrentEvictions: intstr.FromInt32(1)})
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+
+	// Events should only be recorded once even if the node is evaluated multiple times.
+	for range 2 {
+		err = c.reconcile(ctx)
+		require.NoError(t, err)
+	}
+	require.Len(t, recorder.Events, 2)
+	require.Equal(t, corev1.EventTypeNormal+" "+EventReasonTTLExpired+" Node TTL has expired", <-recorder.Events)
+	require.Equal(t, corev1.EventTypeNormal+" "+EventReasonTTLEvictionSkipped+" Skipping TTL eviction: ScaleDownDisabled", <-recorder.Events)
+}
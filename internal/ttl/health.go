@@ -0,0 +1,83 @@
+package ttl
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var evaluationErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_ttl_evaluation_errors_total",
+	Help: "Total number of failed node evaluations, labeled by reason.",
+}, []string{"reason"})
+
+// EvaluationErrorReason describes which part of an evaluation failed.
+type EvaluationErrorReason string
+
+const (
+	EvaluationErrorReasonListNodes               EvaluationErrorReason = "ListNodes"
+	EvaluationErrorReasonListPods                EvaluationErrorReason = "ListPods"
+	EvaluationErrorReasonListPolicies            EvaluationErrorReason = "ListPolicies"
+	EvaluationErrorReasonClusterAutoscalerStatus EvaluationErrorReason = "ClusterAutoscalerStatus"
+	EvaluationErrorReasonNodePoolCapacity        EvaluationErrorReason = "NodePoolCapacity"
+	EvaluationErrorReasonEvictionBudget          EvaluationErrorReason = "EvictionBudget"
+	EvaluationErrorReasonResourceCheck           EvaluationErrorReason = "ResourceCheck"
+	EvaluationErrorReasonUnknown                 EvaluationErrorReason = "Unknown"
+)
+
+// evaluationError is an error which occurred during an evaluation together with the reason it is counted as.
+type evaluationError struct {
+	reason EvaluationErrorReason
+	err    error
+}
+
+func newEvaluationError(reason EvaluationErrorReason, err error) error {
+	return &evaluationError{reason: reason, err: err}
+}
+
+func (e *evaluationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *evaluationError) Unwrap() error {
+	return e.err
+}
+
+// evaluationErrorReason returns the reason of the evaluation error or unknown if the error does not have one.
+func evaluationErrorReason(err error) EvaluationErrorReason {
+	var evalErr *evaluationError
+	if errors.As(err, &evalErr) {
+		return evalErr.reason
+	}
+	return EvaluationErrorReasonUnknown
+}
+
+// Health tracks consecutive failed evaluations so that probes can report a controller which keeps failing.
+// The zero value is ready to use and a nil Health ignores all updates.
+type Health struct {
+	consecutiveFailures atomic.Int64
+}
+
+// ConsecutiveFailures returns the number of evaluations which have failed since the last successful evaluation.
+func (h *Health) ConsecutiveFailures() int64 {
+	if h == nil {
+		return 0
+	}
+	return h.consecutiveFailures.Load()
+}
+
+func (h *Health) recordSuccess() {
+	if h == nil {
+		return
+	}
+	h.consecutiveFailures.Store(0)
+}
+
+func (h *Health) recordFailure() int64 {
+	if h == nil {
+		return 0
+	}
+	return h.consecutiveFailures.Add(1)
+}
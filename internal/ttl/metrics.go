@@ -0,0 +1,121 @@
+package ttl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+var skippedExpiredNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_ttl_skipped_expired_nodes",
+	Help: "Number of nodes with an expired TTL which are not evicted, labeled by node pool and skip reason.",
+}, []string{"node_pool", "reason"})
+
+var nodeAgeSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_ttl_node_age_seconds",
+	Help: "Age of each node with a TTL.",
+}, []string{"node", "node_pool"})
+
+var nodeTTLRemainingSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_ttl_node_ttl_remaining_seconds",
+	Help: "Time until the TTL of each node expires, negative when the TTL has expired.",
+}, []string{"node", "node_pool"})
+
+var drainDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "node_ttl_drain_duration_seconds",
+	Help:    "Time from the start of an eviction until the node has been drained.",
+	Buckets: prometheus.ExponentialBuckets(30, 2, 12),
+}, []string{"node_pool"})
+
+var evictionFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_ttl_eviction_failures_total",
+	Help: "Total number of failed evictions, labeled by node pool and reason.",
+}, []string{"node_pool", "reason"})
+
+var evictedPodsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_ttl_evicted_pods_total",
+	Help: "Total number of Pods evicted or deleted when draining nodes.",
+}, []string{"node_pool"})
+
+var evaluationDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "node_ttl_evaluation_duration_seconds",
+	Help:    "Time taken to evaluate all nodes.",
+	Buckets: prometheus.DefBuckets,
+})
+
+// EvictionFailureReason describes which part of an eviction failed.
+type EvictionFailureReason string
+
+const (
+	EvictionFailureReasonDrainOptions EvictionFailureReason = "DrainOptions"
+	EvictionFailureReasonSurge        EvictionFailureReason = "Surge"
+	EvictionFailureReasonCordon       EvictionFailureReason = "Cordon"
+	EvictionFailureReasonDrain        EvictionFailureReason = "Drain"
+	EvictionFailureReasonDrainTimeout EvictionFailureReason = "DrainTimeout"
+	EvictionFailureReasonMarkDrained  EvictionFailureReason = "MarkDrained"
+	EvictionFailureReasonTerminate    EvictionFailureReason = "Terminate"
+	EvictionFailureReasonUnknown      EvictionFailureReason = "Unknown"
+)
+
+// evictionError is an error which occurred during an eviction together with the reason it is counted as.
+type evictionError struct {
+	reason EvictionFailureReason
+	err    error
+}
+
+func newEvictionError(reason EvictionFailureReason, err error) error {
+	return &evictionError{reason: reason, err: err}
+}
+
+func (e *evictionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *evictionError) Unwrap() error {
+	return e.err
+}
+
+// evictionFailureReason returns the reason of the eviction error or unknown if the error does not have one.
+func evictionFailureReason(err error) EvictionFailureReason {
+	var evictErr *evictionError
+	if errors.As(err, &evictErr) {
+		return evictErr.reason
+	}
+	return EvictionFailureReasonUnknown
+}
+
+// nodePoolLabel returns the node pool used as metric label, which is empty for nodes without a node pool label.
+func nodePoolLabel(resolver status.NodePoolResolver, node *corev1.Node) string {
+	_, value, err := resolver.NodePoolLabel(node)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// recordNodeMetrics exposes the age and remaining TTL of each node and the skipped nodes of the evaluation. Only
+// skipped nodes which have expired are counted, as nodes with an invalid TTL never expire.
+func recordNodeMetrics(eval *evaluation, resolver status.NodePoolResolver, now time.Time) {
+	nodeAgeSeconds.Reset()
+	nodeTTLRemainingSeconds.Reset()
+	skippedExpiredNodes.Reset()
+	for _, node := range eval.nodes {
+		nodePool := nodePoolLabel(resolver, node)
+		if !node.CreationTimestamp.IsZero() {
+			nodeAgeSeconds.WithLabelValues(node.Name, nodePool).Set(now.Sub(node.CreationTimestamp.Time).Seconds())
+		}
+		expiry, ok := eval.expiries[node.Name]
+		if !ok {
+			continue
+		}
+		nodeTTLRemainingSeconds.WithLabelValues(node.Name, nodePool).Set(expiry.Sub(now).Seconds())
+		if reason, ok := eval.skipped[node.Name]; ok && !now.Before(expiry) {
+			skippedExpiredNodes.WithLabelValues(nodePool, string(reason)).Inc()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package ttl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+func TestRecordNodeMetrics(t *testing.T) {
+	expiredOffset := -10 * time.Minute
+	expired := testNodeWithTTL("expired", &expiredOffset, 1*time.Minute, false)
+	expired.Labels[status.KubemarkNodePoolLabelKey] = "pool"
+	expired.Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+	validOffset := -30 * time.Minute
+	valid := testNodeWithTTL("valid", &validOffset, 1*time.Hour, false)
+	valid.Labels[status.KubemarkNodePoolLabelKey] = "pool"
+	invalid := testNodeWithTTL("invalid", &expiredOffset, 1*time.Minute, false)
+	invalid.Labels[status.KubemarkNodePoolLabelKey] = "pool"
+	invalid.Labels[NodeTtlLabelKey] = "forever"
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(expired, valid, invalid)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	now := time.Now()
+	recordNodeMetrics(eval, status.DefaultNodePoolResolver{}, now)
+
+	require.InDelta(t, 1, testutil.ToFloat64(skippedExpiredNodes.WithLabelValues("pool", string(SkipReasonScaleDownDisabled))), 0)
+	require.InDelta(t, (30 * time.Minute).Seconds(), testutil.ToFloat64(nodeAgeSeconds.WithLabelValues("valid", "pool")), 1)
+	require.InDelta(t, (30 * time.Minute).Seconds(), testutil.ToFloat64(nodeTTLRemainingSeconds.WithLabelValues("valid", "pool")), 1)
+	require.Negative(t, testutil.ToFloat64(nodeTTLRemainingSeconds.WithLabelValues("expired", "pool")))
+	// Nodes with an invalid TTL have not expired and are not counted.
+	require.Equal(t, SkipReasonInvalidTTL, eval.skipped["invalid"])
+	require.Equal(t, 1, testutil.CollectAndCount(skippedExpiredNodes))
+
+	// Nodes which no longer have a TTL are removed from the metrics.
+	eval.nodes = eval.nodes[:1]
+	eval.skipped = map[string]SkipReason{}
+	recordNodeMetrics(eval, status.DefaultNodePoolResolver{}, now)
+	require.Equal(t, 1, testutil.CollectAndCount(nodeAgeSeconds))
+	require.Equal(t, 0, testutil.CollectAndCount(skippedExpiredNodes))
+}
+
+func TestEvictionFailureReason(t *testing.T) {
+	err := fmt.Errorf("eviction failed: %w", newEvictionError(EvictionFailureReasonDrainTimeout, errDrainTimeout))
+	require.Equal(t, EvictionFailureReasonDrainTimeout, evictionFailureReason(err))
+	require.ErrorIs(t, err, errDrainTimeout)
+	require.Equal(t, EvictionFailureReasonUnknown, evictionFailureReason(errors.New("unknown")))
+}
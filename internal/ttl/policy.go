@@ -0,0 +1,241 @@
+package ttl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/xenitab/node-ttl/internal/api/v1alpha1"
+	"github.com/xenitab/node-ttl/internal/duration"
+	"github.com/xenitab/node-ttl/internal/maintenance"
+)
+
+// nodePolicy is a parsed NodeTTLPolicy.
+type nodePolicy struct {
+	obj    *unstructured.Unstructured
+	name   string
+	status v1alpha1.NodeTTLPolicyStatus
+
+	priority int
+	selector labels.Selector
+	ttl      time.Duration
+	jitter   time.Duration
+	// maintenanceWindows is nil when the global maintenance windows should be used.
+	maintenanceWindows     []*maintenance.Window
+	maxConcurrentEvictions int
+	drain                  drainOverrides
+}
+
+// drainOverrides contains the drain options set by a policy, nil values are not overridden.
+type drainOverrides struct {
+	timeout                         *time.Duration
+	timeoutPolicy                   *DrainTimeoutPolicy
+	backoff                         *time.Duration
+	force                           *bool
+	gracePeriodSeconds              *int
+	deleteEmptyDirData              *bool
+	podSelector                     *string
+	skipWaitForDeleteTimeoutSeconds *int
+	disableEviction                 *bool
+}
+
+// parsePolicy parses and validates a NodeTTLPolicy. Maintenance windows without a time zone use the location.
+func parsePolicy(obj *unstructured.Unstructured, location *time.Location) (*nodePolicy, error) {
+	policy, err := v1alpha1.FromUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.Everything()
+	if policy.Spec.NodeSelector != nil {
+		selector, err = metav1.LabelSelectorAsSelector(policy.Spec.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector: %w", err)
+		}
+	}
+	ttl, jitter, err := duration.ParseWithJitter(policy.Spec.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ttl: %w", err)
+	}
+	if policy.Spec.Jitter != "" {
+		jitter, err = duration.Parse(policy.Spec.Jitter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jitter: %w", err)
+		}
+	}
+	var windows []*maintenance.Window
+	for _, spec := range policy.Spec.MaintenanceWindows {
+		window, err := maintenance.Parse(spec, location)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	if policy.Spec.MaxConcurrentEvictions < 0 {
+		return nil, fmt.Errorf("max concurrent evictions cannot be negative")
+	}
+	drain, err := parseDrainSpec(policy.Spec.Drain)
+	if err != nil {
+		return nil, err
+	}
+	return &nodePolicy{
+		obj:                    obj,
+		name:                   policy.Name,
+		status:                 policy.Status,
+		priority:               policy.Spec.Priority,
+		selector:               selector,
+		ttl:                    ttl,
+		jitter:                 jitter,
+		maintenanceWindows:     windows,
+		maxConcurrentEvictions: policy.Spec.MaxConcurrentEvictions,
+		drain:                  drain,
+	}, nil
+}
+
+func parseDrainSpec(spec v1alpha1.DrainSpec) (drainOverrides, error) {
+	overrides := drainOverrides{}
+	if spec.Timeout != "" {
+		timeout, err := duration.Parse(spec.Timeout)
+		if err != nil {
+			return drainOverrides{}, fmt.Errorf("invalid drain timeout: %w", err)
+		}
+		overrides.timeout = &timeout
+	}
+	if spec.TimeoutPolicy != "" {
+		timeoutPolicy := DrainTimeoutPolicy(spec.TimeoutPolicy)
+		if timeoutPolicy != DrainTimeoutPolicyUncordon && timeoutPolicy != DrainTimeoutPolicyDeletePods {
+			return drainOverrides{}, fmt.Errorf("invalid drain timeout policy: %s", spec.TimeoutPolicy)
+		}
+		overrides.timeoutPolicy = &timeoutPolicy
+	}
+	if spec.Backoff != "" {
+		backoff, err := duration.Parse(spec.Backoff)
+		if err != nil {
+			return drainOverrides{}, fmt.Errorf("invalid drain backoff: %w", err)
+		}
+		overrides.backoff = &backoff
+	}
+	if spec.PodSelector != nil {
+		if _, err := labels.Parse(*spec.PodSelector); err != nil {
+			return drainOverrides{}, fmt.Errorf("invalid drain pod selector: %w", err)
+		}
+	}
+	if spec.SkipWaitForDeleteTimeoutSeconds != nil && *spec.SkipWaitForDeleteTimeoutSeconds < 0 {
+		return drainOverrides{}, errors.New("drain skip wait for delete timeout cannot be negative")
+	}
+	overrides.force = spec.Force
+	overrides.gracePeriodSeconds = spec.GracePeriodSeconds
+	overrides.deleteEmptyDirData = spec.DeleteEmptyDirData
+	overrides.podSelector = spec.PodSelector
+	overrides.skipWaitForDeleteTimeoutSeconds = spec.SkipWaitForDeleteTimeoutSeconds
+	overrides.disableEviction = spec.DisableEviction
+	return overrides, nil
+}
+
+// listPolicies returns all valid policies ordered by priority, invalid policies are logged and ignored.
+func listPolicies(ctx context.Context, nodeCache *nodeCache, opts Options) ([]*nodePolicy, error) {
+	if nodeCache.policies == nil {
+		return nil, nil
+	}
+	objs, err := nodeCache.nodeTTLPolicies()
+	if err != nil {
+		return nil, err
+	}
+	policies := []*nodePolicy{}
+	for _, obj := range objs {
+		policy, err := parsePolicy(obj, opts.MaintenanceWindowLocation)
+		if err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "ignoring invalid node ttl policy", "policy", obj.GetName())
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].priority != policies[j].priority {
+			return policies[i].priority > policies[j].priority
+		}
+		return policies[i].name < policies[j].name
+	})
+	return policies, nil
+}
+
+// selectPolicy returns the policy with the highest priority matching the node. Policies have to be ordered by priority.
+func selectPolicy(policies []*nodePolicy, node *corev1.Node) *nodePolicy {
+	for _, policy := range policies {
+		if policy.selector.Matches(labels.Set(node.Labels)) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// policyEvictions returns the number of evictions in progress for each policy, including nodes that were skipped.
+func policyEvictions(eval *evaluation, nodes []*corev1.Node, inFlight map[string]struct{}) map[string]int {
+	evicting := map[string]int{}
+	for _, node := range nodes {
+		policy := eval.policies[node.Name]
+		if policy == nil || !evictionInProgress(node, inFlight) {
+			continue
+		}
+		evicting[policy.name]++
+	}
+	return evicting
+}
+
+// policyStatus returns the status of the policy based on the evaluation.
+func policyStatus(policy *nodePolicy, eval *evaluation, lastEviction *v1alpha1.EvictionStatus, now time.Time) v1alpha1.NodeTTLPolicyStatus {
+	status := v1alpha1.NodeTTLPolicyStatus{
+		ObservedGeneration: policy.obj.GetGeneration(),
+		LastEviction:       policy.status.LastEviction,
+	}
+	if lastEviction != nil {
+		status.LastEviction = lastEviction
+	}
+	for nodeName, nodePolicy := range eval.policies {
+		if nodePolicy != policy {
+			continue
+		}
+		status.MatchedNodes++
+		expiry := eval.expiries[nodeName]
+		if expiry.IsZero() || expiry.Before(now) {
+			continue
+		}
+		// The status is stored with second precision.
+		expiry = expiry.Truncate(time.Second)
+		if status.NextExpiry == nil || expiry.Before(status.NextExpiry.Time) {
+			status.NextExpiry = &metav1.Time{Time: expiry}
+		}
+	}
+	return status
+}
+
+// updatePolicyStatuses updates the status of all policies which have changed since the previous evaluation.
+func (c *controller) updatePolicyStatuses(ctx context.Context, eval *evaluation) {
+	log := logr.FromContextOrDiscard(ctx)
+	for _, policy := range eval.nodePolicies {
+		c.mu.Lock()
+		lastEviction := c.lastEvictions[policy.name]
+		c.mu.Unlock()
+		status := policyStatus(policy, eval, lastEviction, time.Now())
+		if equality.Semantic.DeepEqual(status, policy.status) {
+			continue
+		}
+		obj, err := v1alpha1.StatusToUnstructured(policy.obj, status)
+		if err != nil {
+			log.Error(err, "could not convert node ttl policy status", "policy", policy.name)
+			continue
+		}
+		_, err = c.opts.PolicyClient.Resource(v1alpha1.NodeTTLPolicyResource).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+		if err != nil {
+			log.Error(err, "could not update node ttl policy status", "policy", policy.name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package ttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/xenitab/node-ttl/internal/api/v1alpha1"
+)
+
+func testPolicy(name string, priority int, matchLabels map[string]string, ttl string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.Group + "/" + v1alpha1.Version,
+		"kind":       v1alpha1.Kind,
+		"metadata": map[string]interface{}{
+			"name":       name,
+			"generation": int64(1),
+		},
+		"spec": map[string]interface{}{
+			"nodeSelector": map[string]interface{}{"matchLabels": toInterfaceMap(matchLabels)},
+			"ttl":          ttl,
+			"priority":     int64(priority),
+		},
+	}}
+}
+
+func toInterfaceMap(m map[string]string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+func TestParsePolicy(t *testing.T) {
+	obj := testPolicy("policy", 1, map[string]string{"pool": "system"}, "7d~12h")
+	err := unstructured.SetNestedStringSlice(obj.Object, []string{"Mon-Fri 22:00-06:00"}, "spec", "maintenanceWindows")
+	require.NoError(t, err)
+	err = unstructured.SetNestedMap(obj.Object, map[string]interface{}{
+		"timeout":            "1h",
+		"timeoutPolicy":      "DeletePods",
+		"force":              true,
+		"gracePeriodSeconds": int64(60),
+		"podSelector":        "app!=foo",
+	}, "spec", "drain")
+	require.NoError(t, err)
+	policy, err := parsePolicy(obj, time.UTC)
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, policy.ttl)
+	require.Equal(t, 12*time.Hour, policy.jitter)
+	require.Len(t, policy.maintenanceWindows, 1)
+	require.Equal(t, time.Hour, *policy.drain.timeout)
+	require.Equal(t, DrainTimeoutPolicyDeletePods, *policy.drain.timeoutPolicy)
+	require.Nil(t, policy.drain.backoff)
+	require.True(t, *policy.drain.force)
+	require.Equal(t, 60, *policy.drain.gracePeriodSeconds)
+	require.Equal(t, "app!=foo", *policy.drain.podSelector)
+	require.Nil(t, policy.drain.disableEviction)
+
+	invalidTTL := testPolicy("policy", 1, nil, "forever")
+	_, err = parsePolicy(invalidTTL, time.UTC)
+	require.Error(t, err)
+	invalidDrain := testPolicy("policy", 1, nil, "24h")
+	err = unstructured.SetNestedField(invalidDrain.Object, "Ignore", "spec", "drain", "timeoutPolicy")
+	require.NoError(t, err)
+	_, err = parsePolicy(invalidDrain, time.UTC)
+	require.Error(t, err)
+	invalidSelector := testPolicy("policy", 1, nil, "24h")
+	err = unstructured.SetNestedField(invalidSelector.Object, "app in (", "spec", "drain", "podSelector")
+	require.NoError(t, err)
+	_, err = parsePolicy(invalidSelector, time.UTC)
+	require.Error(t, err)
+}
+
+func TestPolicyEvaluation(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	labeled := testNodeWithTTL("labeled", &creationOffset, 1*time.Hour, false)
+	labeled.Labels["pool"] = "system"
+	system := testNodeWithTTL("system", &creationOffset, 0, false)
+	system.Labels = map[string]string{"pool": "system"}
+	user := testNodeWithTTL("user", &creationOffset, 0, false)
+	user.Labels = map[string]string{"pool": "user"}
+	other := testNodeWithTTL("other", &creationOffset, 0, false)
+	other.Labels = map[string]string{"pool": "other"}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(labeled, system, user, other)
+	scheme := runtime.NewScheme()
+	policyClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{v1alpha1.NodeTTLPolicyResource: v1alpha1.Kind + "List"},
+		testPolicy("expired", 10, map[string]string{"pool": "system"}, "1m"),
+		// The policy with the highest priority applies to nodes matched by multiple policies.
+		testPolicy("all", 0, map[string]string{}, "2h"),
+	)
+
+	c, err := newController(ctx, client, Options{DryRun: true, MaxConcurrentEvictions: intstr.FromInt32(10), PolicyClient: policyClient})
+	require.NoError(t, err)
+	eval, err := evaluateNodes(ctx, c.nodeCache, c.opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, 4, eval.total)
+	names := []string{}
+	for _, node := range eval.candidates {
+		names = append(names, node.Name)
+	}
+	require.Equal(t, []string{"system"}, names)
+	require.Equal(t, "all", eval.policies["user"].name)
+	require.Equal(t, "all", eval.policies["other"].name)
+
+	c.updatePolicyStatuses(ctx, eval)
+	obj, err := policyClient.Resource(v1alpha1.NodeTTLPolicyResource).Get(ctx, "all", metav1.GetOptions{})
+	require.NoError(t, err)
+	policy, err := v1alpha1.FromUnstructured(obj)
+	require.NoError(t, err)
+	require.Equal(t, 2, policy.Status.MatchedNodes)
+	require.NotNil(t, policy.Status.NextExpiry)
+	require.Equal(t, int64(1), policy.Status.ObservedGeneration)
+}
+
+func TestPolicyEvictionLimit(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	nodes := []*corev1.Node{
+		testNodeWithTTL("evicting", &creationOffset, 1*time.Minute, true),
+		testNodeWithTTL("waiting", &creationOffset, 1*time.Minute, false),
+	}
+	policy := &nodePolicy{name: "policy", maxConcurrentEvictions: 1}
+	eval := &evaluation{
+		candidates: nodes,
+		skipped:    map[string]SkipReason{},
+		policies:   map[string]*nodePolicy{"evicting": policy, "waiting": policy},
+	}
+	candidates, err := selectCandidates(context.TODO(), eval, nodes, Options{}, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	require.Equal(t, "evicting", candidates[0].Name)
+	require.Equal(t, SkipReasonPolicyEvictionLimit, eval.skipped["waiting"])
+}
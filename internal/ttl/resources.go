@@ -0,0 +1,330 @@
+package ttl
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+// simulatedResources are the resources compared when simulating where drained Pods will be scheduled.
+var simulatedResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourcePods}
+
+// drainSimulation simulates where the Pods of drained nodes will be scheduled during a single evaluation. Pods placed
+// on a node reserve its free resources, so that the Pods of multiple candidates are not placed in the same resources.
+type drainSimulation struct {
+	nodeCache *nodeCache
+	resolver  status.NodePoolResolver
+	inFlight  map[string]struct{}
+	// free contains the allocatable resources which are not requested by any Pod for each node which has been checked.
+	free map[string]corev1.ResourceList
+	// placed contains the Pods which have been placed on each node by the simulation.
+	placed map[string][]*corev1.Pod
+	// removed contains the candidates which will be drained and cannot receive Pods.
+	removed map[string]struct{}
+}
+
+func newDrainSimulation(nodeCache *nodeCache, resolver status.NodePoolResolver, inFlight map[string]struct{}) *drainSimulation {
+	return &drainSimulation{
+		nodeCache: nodeCache,
+		resolver:  resolver,
+		inFlight:  inFlight,
+		free:      map[string]corev1.ResourceList{},
+		placed:    map[string][]*corev1.Pod{},
+		removed:   map[string]struct{}{},
+	}
+}
+
+// drain places the Pods which would be evicted from the node on the other schedulable nodes in its node pool, all nodes
+// are used when the node pool cannot be determined. The first Pod which does not fit is returned, in which case nothing
+// is reserved. Only resource requests, node selectors and taints are considered.
+func (s *drainSimulation) drain(node *corev1.Node, podSelector string) (*corev1.Pod, error) {
+	pods, err := s.evictablePods(node, podSelector)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := s.targetNodes(node)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := s.place(pods, targets, false)
+	if err != nil || pod != nil {
+		return pod, err
+	}
+	s.removed[node.Name] = struct{}{}
+	return nil, nil
+}
+
+// reserveEviction places the Pods of a node which is already being evicted, as they will use the free resources of
+// the other nodes in its node pool before any new candidate is drained. Pods which do not fit are ignored as they
+// will be scheduled to new nodes.
+func (s *drainSimulation) reserveEviction(node *corev1.Node, podSelector string) error {
+	pods, err := s.evictablePods(node, podSelector)
+	if err != nil {
+		return err
+	}
+	targets, err := s.targetNodes(node)
+	if err != nil {
+		return err
+	}
+	_, err = s.place(pods, targets, true)
+	if err != nil {
+		return err
+	}
+	s.removed[node.Name] = struct{}{}
+	return nil
+}
+
+// reservePending places the Pods which are waiting to be scheduled, like Pods already evicted from a node which is
+// being drained, on any schedulable node. Pods which do not fit are ignored as they will be scheduled to new nodes.
+func (s *drainSimulation) reservePending() error {
+	unscheduled, err := s.nodeCache.unscheduledPods()
+	if err != nil {
+		return err
+	}
+	pods := []*corev1.Pod{}
+	for _, pod := range unscheduled {
+		if pod.Status.Phase != corev1.PodPending || !podRequiresResources(pod) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	sortPodsByRequests(pods)
+	targets, err := s.schedulableNodes(labels.Everything(), "")
+	if err != nil {
+		return err
+	}
+	_, err = s.place(pods, targets, true)
+	return err
+}
+
+// place places each Pod on the first target node with enough free resources. When skipUnfit is set Pods which do not
+// fit are skipped, otherwise the first Pod which does not fit is returned and nothing is reserved.
+func (s *drainSimulation) place(pods []*corev1.Pod, targets []*corev1.Node, skipUnfit bool) (*corev1.Pod, error) {
+	free := map[string]corev1.ResourceList{}
+	for _, target := range targets {
+		resources, err := s.freeResources(target)
+		if err != nil {
+			return nil, err
+		}
+		free[target.Name] = resources.DeepCopy()
+	}
+	placed := map[string][]*corev1.Pod{}
+	for _, pod := range pods {
+		requests := podRequests(pod)
+		target := ""
+		for _, node := range targets {
+			if podFitsNode(pod, requests, node, free[node.Name]) {
+				target = node.Name
+				break
+			}
+		}
+		if target == "" {
+			if skipUnfit {
+				continue
+			}
+			return pod, nil
+		}
+		subtractResources(free[target], requests)
+		placed[target] = append(placed[target], pod)
+	}
+	for name, resources := range free {
+		s.free[name] = resources
+		s.placed[name] = append(s.placed[name], placed[name]...)
+	}
+	return nil, nil
+}
+
+// evictablePods returns the Pods which would be evicted when draining the node, including Pods placed on it by the
+// simulation, ordered with the largest requests first.
+func (s *drainSimulation) evictablePods(node *corev1.Node, podSelector string) ([]*corev1.Pod, error) {
+	selector, err := labels.Parse(podSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drain pod selector: %w", err)
+	}
+	nodePods, err := s.nodeCache.podsOnNode(node.Name)
+	if err != nil {
+		return nil, err
+	}
+	pods := []*corev1.Pod{}
+	for _, pod := range nodePods {
+		if !podRequiresResources(pod) || isDaemonSetPod(pod) || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	pods = append(pods, s.placed[node.Name]...)
+	sortPodsByRequests(pods)
+	return pods, nil
+}
+
+// sortPodsByRequests orders the Pods with the largest requests first.
+func sortPodsByRequests(pods []*corev1.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool {
+		a, b := podRequests(pods[i]), podRequests(pods[j])
+		if c := a.Cpu().Cmp(*b.Cpu()); c != 0 {
+			return c > 0
+		}
+		return a.Memory().Cmp(*b.Memory()) > 0
+	})
+}
+
+// targetNodes returns the nodes in the node pool of the node which can receive Pods, ordered by name.
+func (s *drainSimulation) targetNodes(node *corev1.Node) ([]*corev1.Node, error) {
+	selector := labels.Everything()
+	if key, value, err := s.resolver.NodePoolLabel(node); err == nil {
+		selector = labels.SelectorFromSet(labels.Set{key: value})
+	}
+	return s.schedulableNodes(selector, node.Name)
+}
+
+// schedulableNodes returns the nodes matching the selector which can receive Pods, excluding the named node, ordered
+// by name.
+func (s *drainSimulation) schedulableNodes(selector labels.Selector, exclude string) ([]*corev1.Node, error) {
+	nodes, err := s.nodeCache.nodes.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	targets := []*corev1.Node{}
+	for _, target := range nodes {
+		if _, ok := s.removed[target.Name]; ok || target.Name == exclude {
+			continue
+		}
+		if !isNodeReady(target) || target.Spec.Unschedulable || evictionInProgress(target, s.inFlight) {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+	return targets, nil
+}
+
+// freeResources returns the allocatable resources of the node which are not requested by any Pod.
+func (s *drainSimulation) freeResources(node *corev1.Node) (corev1.ResourceList, error) {
+	if free, ok := s.free[node.Name]; ok {
+		return free, nil
+	}
+	free := corev1.ResourceList{}
+	for _, name := range simulatedResources {
+		free[name] = node.Status.Allocatable[name].DeepCopy()
+	}
+	pods, err := s.nodeCache.podsOnNode(node.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if !podRequiresResources(pod) {
+			continue
+		}
+		subtractResources(free, podRequests(pod))
+	}
+	s.free[node.Name] = free
+	return free, nil
+}
+
+// podFitsNode returns true if the Pod can be scheduled to the node with the given free resources.
+func podFitsNode(pod *corev1.Pod, requests corev1.ResourceList, node *corev1.Node, free corev1.ResourceList) bool {
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule || podToleratesTaint(pod, &taint) {
+			continue
+		}
+		return false
+	}
+	for _, name := range simulatedResources {
+		request := requests[name]
+		available := free[name]
+		if request.Cmp(available) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func podToleratesTaint(pod *corev1.Pod, taint *corev1.Taint) bool {
+	for _, toleration := range pod.Spec.Tolerations {
+		if toleration.ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// podRequests returns the CPU and memory requested by the Pod and one Pod slot. The requests are the sum of the
+// containers and sidecar containers or the largest init container, whichever is larger, with the Pod overhead added.
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResources(requests, container.Resources.Requests)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			addResources(requests, container.Resources.Requests)
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			request, ok := container.Resources.Requests[name]
+			if ok && request.Cmp(requests[name]) > 0 {
+				requests[name] = request.DeepCopy()
+			}
+		}
+	}
+	addResources(requests, pod.Spec.Overhead)
+	requests[corev1.ResourcePods] = *resource.NewQuantity(1, resource.DecimalSI)
+	return requests
+}
+
+// podRequiresResources returns true if the Pod is running or will be running, as terminated and terminating Pods
+// will release their resources.
+func podRequiresResources(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	return pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+// isDaemonSetPod returns true if the Pod is managed by a DaemonSet or is a mirror Pod, neither of which are evicted.
+func isDaemonSetPod(pod *corev1.Pod) bool {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return true
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+func addResources(list, add corev1.ResourceList) {
+	for _, name := range simulatedResources {
+		quantity, ok := add[name]
+		if !ok {
+			continue
+		}
+		current := list[name]
+		current.Add(quantity)
+		list[name] = current
+	}
+}
+
+func subtractResources(list, sub corev1.ResourceList) {
+	for _, name := range simulatedResources {
+		quantity, ok := sub[name]
+		if !ok {
+			continue
+		}
+		current := list[name]
+		current.Sub(quantity)
+		list[name] = current
+	}
+}
@@ -0,0 +1,257 @@
+package ttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+func testResources(cpu, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func testResourceNode(name string, expired bool, cpu, memory string) *corev1.Node {
+	creationOffset := -10 * time.Minute
+	ttl := 1 * time.Minute
+	if !expired {
+		ttl = 24 * time.Hour
+	}
+	node := testNodeWithTTL(name, &creationOffset, ttl, false)
+	node.Labels[status.KubemarkNodePoolLabelKey] = "foo"
+	node.Status.Allocatable = testResources(cpu, memory)
+	node.Status.Allocatable[corev1.ResourcePods] = resource.MustParse("110")
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	return node
+}
+
+func testResourcePod(name, nodeName, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{
+				{Name: "app", Resources: corev1.ResourceRequirements{Requests: testResources(cpu, memory)}},
+			},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestPodRequests(t *testing.T) {
+	always := corev1.ContainerRestartPolicyAlways
+	pod := testResourcePod("foo", "node", "500m", "1Gi")
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:      "other",
+		Resources: corev1.ResourceRequirements{Requests: testResources("250m", "1Gi")},
+	})
+	pod.Spec.InitContainers = []corev1.Container{
+		{Name: "init", Resources: corev1.ResourceRequirements{Requests: testResources("1", "512Mi")}},
+		{Name: "sidecar", RestartPolicy: &always, Resources: corev1.ResourceRequirements{Requests: testResources("100m", "128Mi")}},
+	}
+	pod.Spec.Overhead = testResources("50m", "64Mi")
+
+	requests := podRequests(pod)
+	require.Equal(t, "1050m", requests.Cpu().String())
+	require.Equal(t, "2240Mi", requests.Memory().String())
+	require.Equal(t, int64(1), requests.Pods().Value())
+}
+
+func TestResourceCheck(t *testing.T) {
+	daemonSetPod := func(nodeName string) *corev1.Pod {
+		controller := true
+		pod := testResourcePod("daemon-"+nodeName, nodeName, "4", "8Gi")
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "daemon", Controller: &controller}}
+		return pod
+	}
+	completedPod := testResourcePod("completed", "target", "4", "8Gi")
+	completedPod.Status.Phase = corev1.PodSucceeded
+	taintedNode := testResourceNode("target", false, "4", "8Gi")
+	taintedNode.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+	tolerantPod := testResourcePod("app", "expired", "1", "1Gi")
+	tolerantPod.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+	selectorPod := testResourcePod("app", "expired", "1", "1Gi")
+	selectorPod.Spec.NodeSelector = map[string]string{"disk": "ssd"}
+	otherPool := testResourceNode("other", false, "4", "8Gi")
+	otherPool.Labels[status.KubemarkNodePoolLabelKey] = "bar"
+	cordoned := testResourceNode("cordoned", false, "4", "8Gi")
+	cordoned.Spec.Unschedulable = true
+	evicting := testResourceNode("evicting", true, "4", "8Gi")
+	evicting.Spec.Unschedulable = true
+	evicting.Annotations = map[string]string{EvictionStartedAnnotationKey: time.Now().Format(time.RFC3339)}
+	pending := testResourcePod("pending", "", "2", "1Gi")
+	pending.Status.Phase = corev1.PodPending
+	limited := testResourceNode("expired-a", true, "4", "8Gi")
+	limited.Labels[NodePoolMaxConcurrentEvictionsLabelKey] = "1"
+
+	tests := []struct {
+		name       string
+		objects    []runtime.Object
+		candidates []string
+		skipped    []string
+	}{
+		{
+			name: "pods fit",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app", "expired", "1", "1Gi"),
+				testResourcePod("existing", "target", "2", "4Gi"),
+				daemonSetPod("expired"),
+				completedPod,
+			},
+			candidates: []string{"expired"},
+		},
+		{
+			name: "not enough cpu",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app", "expired", "1", "1Gi"),
+				testResourcePod("existing", "target", "3500m", "1Gi"),
+			},
+			skipped: []string{"expired"},
+		},
+		{
+			name: "not enough memory",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app", "expired", "1", "4Gi"),
+				testResourcePod("existing", "target", "1", "6Gi"),
+			},
+			skipped: []string{"expired"},
+		},
+		{
+			name: "pods are spread over multiple nodes",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target-a", false, "2", "8Gi"),
+				testResourceNode("target-b", false, "2", "8Gi"),
+				testResourcePod("app-a", "expired", "1500m", "1Gi"),
+				testResourcePod("app-b", "expired", "1500m", "1Gi"),
+			},
+			candidates: []string{"expired"},
+		},
+		{
+			name: "other node pools and cordoned nodes are not used",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				otherPool,
+				cordoned,
+				testResourcePod("app", "expired", "1", "1Gi"),
+			},
+			skipped: []string{"expired"},
+		},
+		{
+			name: "taint not tolerated",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				taintedNode,
+				testResourcePod("app", "expired", "1", "1Gi"),
+			},
+			skipped: []string{"expired"},
+		},
+		{
+			name: "taint tolerated",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				taintedNode,
+				tolerantPod,
+			},
+			candidates: []string{"expired"},
+		},
+		{
+			name: "node selector not matched",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				selectorPod,
+			},
+			skipped: []string{"expired"},
+		},
+		{
+			name: "candidates reserve resources",
+			objects: []runtime.Object{
+				testResourceNode("expired-a", true, "4", "8Gi"),
+				testResourceNode("expired-b", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app-a", "expired-a", "2", "1Gi"),
+				testResourcePod("app-b", "expired-b", "2", "1Gi"),
+				testResourcePod("existing", "target", "3", "1Gi"),
+			},
+			candidates: []string{"expired-a"},
+			skipped:    []string{"expired-b"},
+		},
+		{
+			name: "candidates removed by eviction limits do not reserve resources",
+			objects: []runtime.Object{
+				evicting,
+				limited,
+				testResourceNode("expired-b", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app-a", "expired-a", "2", "1Gi"),
+				testResourcePod("app-b", "expired-b", "2", "1Gi"),
+				testResourcePod("existing", "target", "3", "1Gi"),
+			},
+			candidates: []string{"evicting", "expired-b"},
+		},
+		{
+			name: "nodes being evicted reserve resources",
+			objects: []runtime.Object{
+				evicting,
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app-a", "evicting", "2", "1Gi"),
+				testResourcePod("app-b", "expired", "2", "1Gi"),
+				testResourcePod("existing", "target", "1", "1Gi"),
+			},
+			candidates: []string{"evicting"},
+			skipped:    []string{"expired"},
+		},
+		{
+			name: "pending pods reserve resources",
+			objects: []runtime.Object{
+				testResourceNode("expired", true, "4", "8Gi"),
+				testResourceNode("target", false, "4", "8Gi"),
+				testResourcePod("app", "expired", "2", "1Gi"),
+				testResourcePod("existing", "target", "1", "1Gi"),
+				pending,
+			},
+			skipped: []string{"expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			client := fake.NewSimpleClientset(tt.objects...)
+			nodeCache, err := newNodeCache(ctx, client, "", nil)
+			require.NoError(t, err)
+			eval, err := evaluateNodes(ctx, nodeCache, Options{ResourceCheck: true}, nil)
+			require.NoError(t, err)
+			candidates := []string{}
+			for _, node := range eval.candidates {
+				candidates = append(candidates, node.Name)
+			}
+			require.ElementsMatch(t, tt.candidates, candidates)
+			for _, name := range tt.skipped {
+				require.Equal(t, SkipReasonInsufficientResources, eval.skipped[name], name)
+			}
+		})
+	}
+}
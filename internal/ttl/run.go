@@ -2,24 +2,487 @@ package ttl
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
 	"time"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/xenitab/node-ttl/internal/api/v1alpha1"
+	"github.com/xenitab/node-ttl/internal/config"
+	"github.com/xenitab/node-ttl/internal/maintenance"
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+// evaluateKey is the only key added to the queue as all nodes are evaluated together.
+const evaluateKey = "evaluate"
+
+const (
+	evaluationBackoffBase = 1 * time.Second
+	evaluationBackoffMax  = 5 * time.Minute
 )
 
-func Run(ctx context.Context, client kubernetes.Interface, interval time.Duration, clusterAutoscalerStatus *types.NamespacedName) error {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// Options configures the evaluation and eviction of nodes.
+type Options struct {
+	// Interval is the resync period at which nodes are evaluated even if no changes have been observed.
+	Interval time.Duration
+	// ClusterAutoscalerStatus is the config map containing the cluster autoscaler status. Node pool capacity is not checked when nil.
+	ClusterAutoscalerStatus *types.NamespacedName
+	// ClusterAutoscalerStatusMaxAge is how old the cluster autoscaler status may be before new evictions are paused,
+	// zero meaning no max age.
+	ClusterAutoscalerStatusMaxAge time.Duration
+	// NodePoolHeadroom is the number of ready nodes above the min size which a node pool has to keep when a new
+	// eviction starts, in addition to the nodes which are already being removed.
+	NodePoolHeadroom int
+	// ResourceCheck only starts new evictions when the resources requested by the Pods of the node are free on the
+	// other nodes in the node pool.
+	ResourceCheck bool
+	// DryRun reports the eviction candidates without evicting them.
+	DryRun bool
+	// MaxConcurrentEvictions is the maximum number of nodes evicted at the same time, either an absolute value or a
+	// percentage of all nodes with a TTL.
+	MaxConcurrentEvictions intstr.IntOrString
+	// MaxConcurrentEvictionsPerNodePool is the default maximum number of nodes evicted at the same time in a single
+	// node pool, zero meaning no limit.
+	MaxConcurrentEvictionsPerNodePool int
+	// NodePoolMaxConcurrentEvictions overrides the maximum number of concurrent evictions for specific node pools.
+	NodePoolMaxConcurrentEvictions map[string]int
+	// MaintenanceWindows restricts when new evictions may start, evictions may always start when empty.
+	MaintenanceWindows []*maintenance.Window
+	// MaintenanceWindowLocation is the default time zone used when parsing maintenance windows from node annotations.
+	MaintenanceWindowLocation *time.Location
+	// PolicyClient is used to read NodeTTLPolicies, policies are not used when nil.
+	PolicyClient dynamic.Interface
+	// TTLRules set the TTL of nodes without a TTL label, the first matching rule is used.
+	TTLRules []config.TTLRule
+	// DrainTimeout is how long a drain may take before the drain timeout policy is applied, zero meaning no timeout.
+	DrainTimeout time.Duration
+	// DrainTimeoutPolicy decides what happens to a node which has not been drained within the drain timeout.
+	DrainTimeoutPolicy DrainTimeoutPolicy
+	// DrainBackoff is how long to wait before evicting a node again after it has been uncordoned due to a drain timeout.
+	DrainBackoff time.Duration
+	// DrainForce allows Pods which are not managed by a controller to be deleted.
+	DrainForce bool
+	// DrainGracePeriodSeconds overrides the termination grace period of Pods, a negative value uses the grace period of the Pod.
+	DrainGracePeriodSeconds int
+	// DrainDeleteEmptyDirData allows Pods using emptyDir volumes to be deleted, deleting the data in the volumes.
+	DrainDeleteEmptyDirData bool
+	// DrainPodSelector is a label selector limiting which Pods are drained, all Pods are drained when empty.
+	DrainPodSelector string
+	// DrainSkipWaitForDeleteTimeoutSeconds skips waiting for Pods which have been deleted for longer than the
+	// timeout, zero meaning always waiting.
+	DrainSkipWaitForDeleteTimeoutSeconds int
+	// DrainDisableEviction deletes Pods instead of evicting them, bypassing Pod Disruption Budgets.
+	DrainDisableEviction bool
+	// Surge adds a new node to node pools without capacity for scale down before draining an expired node, instead of
+	// skipping the node. Requires the cluster autoscaler status.
+	Surge bool
+	// SurgeNamespace is the namespace of the placeholder Pods which trigger the scale up.
+	SurgeNamespace string
+	// SurgeImage is the image of the placeholder Pods.
+	SurgeImage string
+	// SurgeTimeout is how long to wait for the new node to become ready.
+	SurgeTimeout time.Duration
+	// Terminator removes nodes after they have been drained, drained nodes are left to cluster autoscaler when nil.
+	Terminator NodeTerminator
+	// RemovalTimeout is how long a drained node may remain before it is reported as not removed, zero meaning never.
+	RemovalTimeout time.Duration
+	// NodePoolResolver determines the node pool of nodes, the default resolver supporting AKS, EKS and kubemark is used
+	// when nil.
+	NodePoolResolver status.NodePoolResolver
+	// Identity identifies this instance in the annotation added to nodes which are being evicted.
+	Identity string
+}
+
+// nodePoolResolver returns the configured node pool resolver or the default resolver if none is configured.
+func (o Options) nodePoolResolver() status.NodePoolResolver {
+	if o.NodePoolResolver == nil {
+		return status.DefaultNodePoolResolver{}
+	}
+	return o.NodePoolResolver
+}
+
+// Validate returns an error if the options are invalid.
+func (o Options) Validate() error {
+	if o.Interval <= 0 {
+		return errors.New("interval has to be larger than zero")
+	}
+	value, err := intstr.GetScaledValueFromIntOrPercent(&o.MaxConcurrentEvictions, 100, true)
+	if err != nil {
+		return fmt.Errorf("invalid max concurrent evictions: %w", err)
+	}
+	if value < 1 {
+		return errors.New("max concurrent evictions has to be at least one")
+	}
+	if o.MaxConcurrentEvictionsPerNodePool < 0 {
+		return errors.New("max concurrent evictions per node pool cannot be negative")
+	}
+	for nodePool, limit := range o.NodePoolMaxConcurrentEvictions {
+		if limit < 0 {
+			return fmt.Errorf("max concurrent evictions for node pool %s cannot be negative", nodePool)
+		}
+	}
+	if _, err := nodeDrainOptions(o, nil, nil); err != nil {
+		return err
+	}
+	if o.ClusterAutoscalerStatusMaxAge < 0 {
+		return errors.New("cluster autoscaler status max age cannot be negative")
+	}
+	if o.NodePoolHeadroom < 0 {
+		return errors.New("node pool headroom cannot be negative")
+	}
+	if o.RemovalTimeout < 0 {
+		return errors.New("removal timeout cannot be negative")
+	}
+	if o.Surge {
+		if o.ClusterAutoscalerStatus == nil {
+			return errors.New("surge requires the cluster autoscaler status")
+		}
+		if o.SurgeNamespace == "" {
+			return errors.New("surge namespace has to be set when surge is enabled")
+		}
+		if o.SurgeImage == "" {
+			return errors.New("surge image has to be set when surge is enabled")
+		}
+		if o.SurgeTimeout <= 0 {
+			return errors.New("surge timeout has to be larger than zero")
+		}
+	}
+	switch o.DrainTimeoutPolicy {
+	case "", DrainTimeoutPolicyUncordon:
+		if o.DrainTimeout > 0 && o.DrainBackoff <= 0 {
+			return errors.New("drain backoff has to be larger than zero")
+		}
+	case DrainTimeoutPolicyDeletePods:
+	default:
+		return fmt.Errorf("invalid drain timeout policy: %s", o.DrainTimeoutPolicy)
+	}
+	return nil
+}
+
+type controller struct {
+	client    kubernetes.Interface
+	nodeCache *nodeCache
+	queue     workqueue.TypedRateLimitingInterface[string]
+	opts      Options
+
+	recorder record.EventRecorder
+
+	// skipped is the skip reason of each node from the previous evaluation.
+	skipped map[string]SkipReason
+	// expired contains the nodes with an expired TTL from the previous evaluation.
+	expired map[string]struct{}
+	// removalTimedOut contains the drained nodes which had not been removed within the removal timeout in the previous
+	// evaluation.
+	removalTimedOut map[string]struct{}
+
+	// mu protects inFlight and lastEvictions which are modified by the eviction goroutines.
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+	// lastEvictions contains the last completed eviction of each policy.
+	lastEvictions map[string]*v1alpha1.EvictionStatus
+	// wg tracks running eviction goroutines.
+	wg sync.WaitGroup
+
+	// backoff delays the next evaluation after consecutive failed evaluations.
+	backoff workqueue.TypedRateLimiter[string]
+	// retryAt is the earliest time a failed evaluation may be retried, other evaluations are skipped until then.
+	retryAt time.Time
+	health  *Health
+	// report exposes the outcome of evaluations and evictions through the status API.
+	report *Report
+}
+
+// Run evaluates nodes when a node TTL expires, when a change which could affect the evaluation is observed and
+// at least once every interval. Failed evaluations are retried with an exponential backoff and reported to health.
+// The outcome of each evaluation and eviction is recorded in the report.
+func Run(ctx context.Context, client kubernetes.Interface, opts Options, health *Health, report *Report) error {
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+	c, err := newController(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+	c.health = health
+	c.report = report
+	defer c.queue.ShutDown()
+
+	// Interval is used as a resync period to make sure that nodes are evaluated even if no events occur.
+	go wait.Until(func() {
+		c.queue.Add(evaluateKey)
+	}, opts.Interval, ctx.Done())
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+
 	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-ticker.C:
-			err := evictNextExpiredNode(ctx, client, clusterAutoscalerStatus)
-			if err != nil {
-				return err
+		key, shutdown := c.queue.Get()
+		if shutdown {
+			break
+		}
+		c.processNext(ctx, key)
+		c.queue.Done(key)
+	}
+	c.wg.Wait()
+	return nil
+}
+
+// processNext runs an evaluation unless a failed evaluation is backing off. A failure never stops the controller,
+// instead the evaluation is retried after an exponentially increasing delay.
+func (c *controller) processNext(ctx context.Context, key string) {
+	if time.Now().Before(c.retryAt) {
+		return
+	}
+	err := c.reconcile(ctx)
+	if err == nil {
+		c.backoff.Forget(key)
+		c.retryAt = time.Time{}
+		c.health.recordSuccess()
+		return
+	}
+	reason := evaluationErrorReason(err)
+	evaluationErrorsTotal.WithLabelValues(string(reason)).Inc()
+	failures := c.health.recordFailure()
+	delay := c.backoff.When(key)
+	c.retryAt = time.Now().Add(delay)
+	c.queue.AddAfter(key, delay)
+	logr.FromContextOrDiscard(ctx).Error(err, "evaluation failed", "reason", reason, "consecutiveFailures", failures, "retryAfter", delay)
+}
+
+func newController(ctx context.Context, client kubernetes.Interface, opts Options) (*controller, error) {
+	// Nodes without a TTL label have to be watched when they can get a TTL from a rule or a policy, or when they can
+	// receive the Pods of a drained node.
+	nodeSelector := NodeTtlLabelKey
+	if len(opts.TTLRules) > 0 || opts.PolicyClient != nil || opts.ResourceCheck {
+		nodeSelector = ""
+	}
+	nodeCache, err := newNodeCache(ctx, client, nodeSelector, opts.ClusterAutoscalerStatus)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PolicyClient != nil {
+		err = nodeCache.watchPolicies(ctx, opts.PolicyClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c := &controller{
+		client:    client,
+		nodeCache: nodeCache,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "node-ttl"},
+		),
+		opts:            opts,
+		recorder:        newEventRecorder(ctx, client),
+		skipped:         map[string]SkipReason{},
+		expired:         map[string]struct{}{},
+		removalTimedOut: map[string]struct{}{},
+		inFlight:        map[string]struct{}{},
+		lastEvictions:   map[string]*v1alpha1.EvictionStatus{},
+		backoff:         workqueue.NewTypedItemExponentialFailureRateLimiter[string](evaluationBackoffBase, evaluationBackoffMax),
+	}
+	err = nodeCache.addEventHandler(c.eventHandler())
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reconcile starts evicting candidates within the eviction budget and schedules the next evaluation.
+func (c *controller) reconcile(ctx context.Context) error {
+	c.mu.Lock()
+	inFlight := maps.Clone(c.inFlight)
+	c.mu.Unlock()
+	evaluationStarted := time.Now()
+	eval, err := evaluateNodes(ctx, c.nodeCache, c.opts, inFlight)
+	evaluationDurationSeconds.Observe(time.Since(evaluationStarted).Seconds())
+	if err != nil {
+		return err
+	}
+	recordNodeMetrics(eval, c.opts.nodePoolResolver(), time.Now())
+	c.report.recordEvaluation(nodeStatuses(eval, c.opts, inFlight, time.Now()), time.Now())
+	c.recordExpired(eval)
+	c.recordPendingRemoval(ctx, eval)
+	c.recordSkipped(eval)
+	c.logSkipped(ctx, eval)
+	if c.opts.PolicyClient != nil {
+		c.updatePolicyStatuses(ctx, eval)
+	}
+	if !eval.nextEvaluation.IsZero() {
+		c.queue.AddAfter(evaluateKey, time.Until(eval.nextEvaluation))
+	}
+	if maintenance.Contains(c.opts.MaintenanceWindows, time.Now()) {
+		inMaintenanceWindowGauge.Set(1)
+	} else {
+		inMaintenanceWindowGauge.Set(0)
+	}
+
+	budget, err := intstr.GetScaledValueFromIntOrPercent(&c.opts.MaxConcurrentEvictions, eval.total, true)
+	if err != nil {
+		return newEvaluationError(EvaluationErrorReasonEvictionBudget, err)
+	}
+	if c.opts.DryRun {
+		reportDryRun(ctx, eval, eval.candidates[:min(budget, len(eval.candidates))])
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, node := range evictionsToStart(eval.candidates, c.inFlight, budget) {
+		_, surge := eval.surge[node.Name]
+		c.startEviction(ctx, node, eval.policies[node.Name], surge)
+	}
+	return nil
+}
+
+// evictionsToStart returns the candidates which should start being evicted without exceeding the budget.
+func evictionsToStart(candidates []*corev1.Node, inFlight map[string]struct{}, budget int) []*corev1.Node {
+	available := budget - len(inFlight)
+	nodes := []*corev1.Node{}
+	for _, node := range candidates {
+		if available <= 0 {
+			break
+		}
+		if _, ok := inFlight[node.Name]; ok {
+			continue
+		}
+		nodes = append(nodes, node)
+		available--
+	}
+	return nodes
+}
+
+// startEviction evicts the node in a separate goroutine, adding a new node to the node pool first when surge is set.
+// Must be called while holding the lock.
+func (c *controller) startEviction(ctx context.Context, node *corev1.Node, policy *nodePolicy, surge bool) {
+	record := EvictionRecord{Node: node.Name, NodePool: nodePoolLabel(c.opts.nodePoolResolver(), node), Surge: surge, StartedAt: time.Now()}
+	if policy != nil {
+		record.Policy = policy.name
+	}
+	drainOpts, err := nodeDrainOptions(c.opts, policy, node)
+	if err != nil {
+		id := c.report.recordEvictionStarted(record)
+		c.report.recordEvictionCompleted(id, newEvictionError(EvictionFailureReasonDrainOptions, err), time.Now())
+		evictionFailuresTotal.WithLabelValues(nodePoolLabel(c.opts.nodePoolResolver(), node), string(EvictionFailureReasonDrainOptions)).Inc()
+		logr.FromContextOrDiscard(ctx).Error(err, "could not determine drain options", "node", node.Name)
+		c.recorder.Event(node, corev1.EventTypeWarning, EventReasonTTLEvictionFailed, err.Error())
+		return
+	}
+	c.inFlight[node.Name] = struct{}{}
+	id := c.report.recordEvictionStarted(record)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.recorder.Event(node, corev1.EventTypeNormal, EventReasonTTLEvictionStarted, "Cordoning and draining node with expired TTL")
+		err := c.evict(ctx, node, drainOpts, surge)
+		c.report.recordEvictionCompleted(id, err, time.Now())
+		c.mu.Lock()
+		delete(c.inFlight, node.Name)
+		if err == nil && policy != nil {
+			// The status is stored with second precision.
+			c.lastEvictions[policy.name] = &v1alpha1.EvictionStatus{Node: node.Name, Time: metav1.NewTime(time.Now().Truncate(time.Second))}
+		}
+		c.mu.Unlock()
+		// Evictions interrupted by shutdown are resumed by the next instance.
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// A failed eviction does not stop other evictions, it is resumed or retried by a later evaluation.
+			reason := evictionFailureReason(err)
+			evictionFailuresTotal.WithLabelValues(nodePoolLabel(c.opts.nodePoolResolver(), node), string(reason)).Inc()
+			logr.FromContextOrDiscard(ctx).Error(err, "eviction failed", "node", node.Name, "reason", reason)
+			c.recorder.Event(node, corev1.EventTypeWarning, EventReasonTTLEvictionFailed, err.Error())
+			return
+		}
+		c.recorder.Event(node, corev1.EventTypeNormal, EventReasonTTLEvictionCompleted, "Node has been drained")
+		// Evaluate directly after an eviction completes as another node may be waiting.
+		c.queue.Add(evaluateKey)
+	}()
+}
+
+// logSkipped logs skip reasons when they change to avoid logging the same reason on every evaluation.
+func (c *controller) logSkipped(ctx context.Context, eval *evaluation) {
+	log := logr.FromContextOrDiscard(ctx)
+	for nodeName, reason := range eval.skipped {
+		if c.skipped[nodeName] == reason {
+			continue
+		}
+		log.Info("skipping node with expired ttl", "node", nodeName, "reason", reason)
+	}
+	c.skipped = eval.skipped
+}
+
+// eventHandler enqueues an evaluation for changes which could affect the outcome of the evaluation.
+func (c *controller) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			// New Pods can only block an eviction which is determined during evaluation anyways, unless the resource
+			// check is enabled as they use resources which the Pods of a drained node may need.
+			if _, ok := obj.(*corev1.Pod); ok && !c.opts.ResourceCheck {
+				return
+			}
+			c.queue.Add(evaluateKey)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !relevantUpdate(oldObj, newObj) {
+				return
 			}
+			c.queue.Add(evaluateKey)
+		},
+		DeleteFunc: func(obj interface{}) {
+			observeRemoval(obj)
+			c.queue.Add(evaluateKey)
+		},
+	}
+}
+
+// relevantUpdate filters out frequent updates which do not affect the evaluation, like node status heartbeats. Node
+// readiness and allocatable resources are relevant as they decide where Pods can be scheduled.
+func relevantUpdate(oldObj, newObj interface{}) bool {
+	switch newObj := newObj.(type) {
+	case *corev1.Node:
+		oldNode, ok := oldObj.(*corev1.Node)
+		if !ok {
+			return true
+		}
+		return !equality.Semantic.DeepEqual(oldNode.Labels, newObj.Labels) ||
+			!equality.Semantic.DeepEqual(oldNode.Annotations, newObj.Annotations) ||
+			!equality.Semantic.DeepEqual(oldNode.Spec, newObj.Spec) ||
+			isNodeReady(oldNode) != isNodeReady(newObj) ||
+			!equality.Semantic.DeepEqual(oldNode.Status.Allocatable, newObj.Status.Allocatable)
+	case *corev1.Pod:
+		oldPod, ok := oldObj.(*corev1.Pod)
+		if !ok {
+			return true
+		}
+		return oldPod.Annotations[PodSafeToEvictKey] != newObj.Annotations[PodSafeToEvictKey]
+	case *unstructured.Unstructured:
+		// Status updates of policies do not change the generation.
+		oldPolicy, ok := oldObj.(*unstructured.Unstructured)
+		if !ok {
+			return true
 		}
+		return oldPolicy.GetGeneration() != newObj.GetGeneration()
+	default:
+		return true
 	}
 }
@@ -0,0 +1,203 @@
+package ttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestRelevantUpdate(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node",
+			Labels: map[string]string{NodeTtlLabelKey: "24h"},
+		},
+		Status: corev1.NodeStatus{
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+		},
+	}
+	heartbeat := node.DeepCopy()
+	heartbeat.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+	require.False(t, relevantUpdate(node, heartbeat))
+	notReady := node.DeepCopy()
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	require.True(t, relevantUpdate(node, notReady))
+	resized := node.DeepCopy()
+	resized.Status.Allocatable[corev1.ResourceCPU] = resource.MustParse("4")
+	require.True(t, relevantUpdate(node, resized))
+	cordoned := node.DeepCopy()
+	cordoned.Spec.Unschedulable = true
+	require.True(t, relevantUpdate(node, cordoned))
+	annotated := node.DeepCopy()
+	annotated.Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+	require.True(t, relevantUpdate(node, annotated))
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			Annotations: map[string]string{PodSafeToEvictKey: "false"},
+		},
+	}
+	running := pod.DeepCopy()
+	running.Status.Phase = corev1.PodRunning
+	require.False(t, relevantUpdate(pod, running))
+	safeToEvict := pod.DeepCopy()
+	safeToEvict.Annotations[PodSafeToEvictKey] = "true"
+	require.True(t, relevantUpdate(pod, safeToEvict))
+}
+
+func TestEventHandlerPodAdd(t *testing.T) {
+	for _, resourceCheck := range []bool{false, true} {
+		c := &controller{
+			opts:  Options{ResourceCheck: resourceCheck},
+			queue: workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		}
+		c.eventHandler().OnAdd(&corev1.Pod{}, false)
+		// New Pods are only relevant when the resource check is enabled.
+		require.Equal(t, resourceCheck, c.queue.Len() == 1)
+		c.queue.ShutDown()
+	}
+}
+
+func TestEvictionsToStart(t *testing.T) {
+	candidates := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "first"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "second"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "third"}},
+	}
+
+	type test struct {
+		name     string
+		inFlight []string
+		budget   int
+		expected []string
+	}
+	tests := []test{
+		{
+			name:     "nothing in flight",
+			budget:   2,
+			expected: []string{"first", "second"},
+		},
+		{
+			name:     "candidate already in flight",
+			inFlight: []string{"first"},
+			budget:   2,
+			expected: []string{"second"},
+		},
+		{
+			name:     "budget exhausted by other node",
+			inFlight: []string{"other"},
+			budget:   1,
+			expected: []string{},
+		},
+		{
+			name:     "budget larger than candidates",
+			budget:   10,
+			expected: []string{"first", "second", "third"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlight := map[string]struct{}{}
+			for _, name := range tt.inFlight {
+				inFlight[name] = struct{}{}
+			}
+			names := []string{}
+			for _, node := range evictionsToStart(candidates, inFlight, tt.budget) {
+				names = append(names, node.Name)
+			}
+			require.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	opts := Options{Interval: time.Minute, MaxConcurrentEvictions: intstr.FromString("10%")}
+	require.NoError(t, opts.Validate())
+	opts.MaxConcurrentEvictions = intstr.FromInt32(0)
+	require.Error(t, opts.Validate())
+	opts.MaxConcurrentEvictions = intstr.FromString("foo")
+	require.Error(t, opts.Validate())
+
+	opts = Options{Interval: time.Minute, MaxConcurrentEvictions: intstr.FromInt32(1), DrainTimeout: time.Hour}
+	require.Error(t, opts.Validate())
+	opts.DrainBackoff = time.Hour
+	require.NoError(t, opts.Validate())
+	opts.DrainTimeoutPolicy = "Foo"
+	require.Error(t, opts.Validate())
+	opts.DrainTimeoutPolicy = DrainTimeoutPolicyDeletePods
+	opts.DrainBackoff = 0
+	require.NoError(t, opts.Validate())
+	opts.DrainPodSelector = "=foo"
+	require.Error(t, opts.Validate())
+	opts.DrainPodSelector = "app!=critical"
+	opts.DrainSkipWaitForDeleteTimeoutSeconds = -1
+	require.Error(t, opts.Validate())
+	opts.DrainSkipWaitForDeleteTimeoutSeconds = 0
+	opts.ClusterAutoscalerStatusMaxAge = -time.Minute
+	require.Error(t, opts.Validate())
+	opts.ClusterAutoscalerStatusMaxAge = 0
+	opts.NodePoolHeadroom = -1
+	require.Error(t, opts.Validate())
+
+	opts = Options{Interval: time.Minute, MaxConcurrentEvictions: intstr.FromInt32(1), Surge: true}
+	require.Error(t, opts.Validate())
+	opts.ClusterAutoscalerStatus = &types.NamespacedName{Namespace: "cluster-autoscaler", Name: "cluster-autoscaler-status"}
+	opts.SurgeNamespace = "node-ttl"
+	opts.SurgeImage = "registry.k8s.io/pause:3.10"
+	require.Error(t, opts.Validate())
+	opts.SurgeTimeout = 15 * time.Minute
+	require.NoError(t, opts.Validate())
+}
+
+func TestProcessNextFailure(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, false)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(node)
+	// The cluster autoscaler status config map does not exist which fails the evaluation.
+	opts := Options{
+		MaxConcurrentEvictions:  intstr.FromInt32(1),
+		ClusterAutoscalerStatus: &types.NamespacedName{Namespace: "cluster-autoscaler", Name: "cluster-autoscaler-status"},
+	}
+	c, err := newController(ctx, client, opts)
+	require.NoError(t, err)
+	c.health = &Health{}
+	defer c.queue.ShutDown()
+
+	reason := string(EvaluationErrorReasonClusterAutoscalerStatus)
+	before := testutil.ToFloat64(evaluationErrorsTotal.WithLabelValues(reason))
+	c.processNext(ctx, evaluateKey)
+	require.Equal(t, int64(1), c.health.ConsecutiveFailures())
+	require.InDelta(t, before+1, testutil.ToFloat64(evaluationErrorsTotal.WithLabelValues(reason)), 0)
+	require.True(t, c.retryAt.After(time.Now()))
+
+	// Evaluations are skipped while backing off.
+	c.processNext(ctx, evaluateKey)
+	require.Equal(t, int64(1), c.health.ConsecutiveFailures())
+
+	c.retryAt = time.Time{}
+	c.processNext(ctx, evaluateKey)
+	require.Equal(t, int64(2), c.health.ConsecutiveFailures())
+
+	// A successful evaluation resets the failures.
+	c.opts.ClusterAutoscalerStatus = nil
+	c.opts.DryRun = true
+	c.retryAt = time.Time{}
+	c.processNext(ctx, evaluateKey)
+	require.Equal(t, int64(0), c.health.ConsecutiveFailures())
+	require.True(t, c.retryAt.IsZero())
+}
@@ -0,0 +1,178 @@
+package ttl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const surgePodPrefix = "node-ttl-surge-"
+
+// surgeCheckInterval is how often the placeholder Pod is checked while waiting for a new node.
+var surgeCheckInterval = 10 * time.Second
+
+// surge creates a placeholder Pod which can only be scheduled to a new node in the node pool of the expired node and
+// waits until it is running on a ready node. The placeholder Pod makes cluster autoscaler scale up the node pool, after
+// which the expired node can be removed without going below the min count of the node pool.
+func (c *controller) surge(ctx context.Context, node *corev1.Node) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("node", node.Name)
+	key, value, err := c.opts.nodePoolResolver().NodePoolLabel(node)
+	if err != nil {
+		return err
+	}
+	poolNodes, err := c.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: labels.Set{key: value}.String()})
+	if err != nil {
+		return err
+	}
+	pod := newSurgePod(node, key, value, poolNodes.Items, c.opts.SurgeNamespace, c.opts.SurgeImage)
+	_, err = c.client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	// The placeholder Pod is kept when an interrupted surge is resumed.
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("could not create surge pod for node %s: %w", node.Name, err)
+	}
+	log.Info("waiting for new node before draining", "pod", pod.Name)
+	c.recorder.Eventf(node, corev1.EventTypeNormal, EventReasonTTLSurge,
+		"Waiting for a new node in node pool %s before draining", value)
+	var newNode string
+	err = wait.PollUntilContextTimeout(ctx, surgeCheckInterval, c.opts.SurgeTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := c.client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if current.Spec.NodeName == "" {
+			return false, nil
+		}
+		scheduled, err := c.client.CoreV1().Nodes().Get(ctx, current.Spec.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		newNode = scheduled.Name
+		return isNodeReady(scheduled), nil
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			c.deleteSurgePod(ctx, node)
+		}
+		return fmt.Errorf("new node for node %s was not ready within %s: %w", node.Name, c.opts.SurgeTimeout, err)
+	}
+	log.Info("new node is ready", "newNode", newNode)
+	return nil
+}
+
+// deleteSurgePod removes the placeholder Pod of the node if it exists.
+func (c *controller) deleteSurgePod(ctx context.Context, node *corev1.Node) {
+	name := surgePodName(node.Name)
+	err := c.client.CoreV1().Pods(c.opts.SurgeNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logr.FromContextOrDiscard(ctx).Error(err, "could not delete surge pod", "node", node.Name, "pod", name)
+	}
+}
+
+// newSurgePod returns a placeholder Pod which requires a node in the node pool other than the current nodes.
+func newSurgePod(node *corev1.Node, poolLabelKey, poolLabelValue string, poolNodes []corev1.Node, namespace, image string) *corev1.Pod {
+	hostnames := []string{}
+	for _, poolNode := range poolNodes {
+		hostname, ok := poolNode.Labels[corev1.LabelHostname]
+		if !ok {
+			hostname = poolNode.Name
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	// The new node will most likely have the same taints as the other nodes in the node pool.
+	tolerations := []corev1.Toleration{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == EvictionTaintKey || taint.Key == corev1.TaintNodeUnschedulable {
+			continue
+		}
+		tolerations = append(tolerations, corev1.Toleration{Key: taint.Key, Operator: corev1.TolerationOpExists, Effect: taint.Effect})
+	}
+	automountServiceAccountToken := false
+	var terminationGracePeriodSeconds int64
+	runAsNonRoot := true
+	var runAsUser int64 = 65532
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	affinity := &corev1.Affinity{}
+	if len(hostnames) > 0 {
+		affinity.NodeAffinity = &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{Key: corev1.LabelHostname, Operator: corev1.NodeSelectorOpNotIn, Values: hostnames},
+						},
+					},
+				},
+			},
+		}
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      surgePodName(node.Name),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "node-ttl-surge",
+				"app.kubernetes.io/managed-by": eventComponent,
+			},
+			Annotations: map[string]string{
+				SurgeNodeAnnotationKey: node.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeSelector:                  map[string]string{poolLabelKey: poolLabelValue},
+			Affinity:                      affinity,
+			Tolerations:                   tolerations,
+			AutomountServiceAccountToken:  &automountServiceAccountToken,
+			TerminationGracePeriodSeconds: &terminationGracePeriodSeconds,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				RunAsUser:      &runAsUser,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "pause",
+					Image: image,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("1m"),
+							corev1.ResourceMemory: resource.MustParse("8Mi"),
+						},
+					},
+					SecurityContext: &corev1.SecurityContext{
+						AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+						ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+						Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// surgePodName returns the name of the placeholder Pod of the node.
+func surgePodName(nodeName string) string {
+	name := surgePodPrefix + nodeName
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		name = name[:validation.DNS1123SubdomainMaxLength]
+	}
+	return name
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
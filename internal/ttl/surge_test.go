@@ -0,0 +1,100 @@
+package ttl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/xenitab/node-ttl/internal/status"
+)
+
+func testPoolNode(name string, ready bool) *corev1.Node {
+	conditionStatus := corev1.ConditionFalse
+	if ready {
+		conditionStatus = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{status.KubemarkNodePoolLabelKey: "pool", corev1.LabelHostname: name},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: conditionStatus}},
+		},
+	}
+}
+
+func TestNewSurgePod(t *testing.T) {
+	node := testPoolNode("expired", true)
+	node.Spec.Taints = []corev1.Taint{
+		{Key: "dedicated", Value: "system", Effect: corev1.TaintEffectNoSchedule},
+		{Key: EvictionTaintKey, Effect: corev1.TaintEffectNoSchedule},
+	}
+	poolNodes := []corev1.Node{*node, *testPoolNode("other", true)}
+	pod := newSurgePod(node, status.KubemarkNodePoolLabelKey, "pool", poolNodes, "node-ttl", "pause")
+	require.Equal(t, "node-ttl-surge-expired", pod.Name)
+	require.Equal(t, map[string]string{status.KubemarkNodePoolLabelKey: "pool"}, pod.Spec.NodeSelector)
+	requirement := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0]
+	require.Equal(t, corev1.NodeSelectorOpNotIn, requirement.Operator)
+	require.Equal(t, []string{"expired", "other"}, requirement.Values)
+	toleration := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	require.Equal(t, []corev1.Toleration{toleration}, pod.Spec.Tolerations)
+}
+
+func TestSurge(t *testing.T) {
+	surgeCheckInterval = 10 * time.Millisecond
+	node := testPoolNode("expired", true)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(node, testPoolNode("new", true))
+	c := &controller{
+		client:   client,
+		opts:     Options{SurgeNamespace: "node-ttl", SurgeImage: "pause", SurgeTimeout: 10 * time.Second},
+		recorder: record.NewFakeRecorder(10),
+	}
+	// Schedule the placeholder Pod to the new node once it has been created.
+	go func() {
+		for ctx.Err() == nil {
+			pod, err := client.CoreV1().Pods("node-ttl").Get(ctx, surgePodName("expired"), metav1.GetOptions{})
+			if err == nil {
+				pod.Spec.NodeName = "new"
+				_, err = client.CoreV1().Pods("node-ttl").Update(ctx, pod, metav1.UpdateOptions{})
+				if err == nil {
+					return
+				}
+			}
+			time.Sleep(surgeCheckInterval)
+		}
+	}()
+	err := c.surge(ctx, node)
+	require.NoError(t, err)
+	c.deleteSurgePod(ctx, node)
+	_, err = client.CoreV1().Pods("node-ttl").Get(ctx, surgePodName("expired"), metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func TestSurgeTimeout(t *testing.T) {
+	surgeCheckInterval = 10 * time.Millisecond
+	node := testPoolNode("expired", true)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	c := &controller{
+		client:   client,
+		opts:     Options{SurgeNamespace: "node-ttl", SurgeImage: "pause", SurgeTimeout: 50 * time.Millisecond},
+		recorder: record.NewFakeRecorder(10),
+	}
+	err := c.surge(ctx, node)
+	require.Error(t, err)
+	// The placeholder Pod is removed when no new node became ready in time.
+	_, err = client.CoreV1().Pods("node-ttl").Get(ctx, surgePodName("expired"), metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,144 @@
+package ttl
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	k8sretry "k8s.io/client-go/util/retry"
+)
+
+var drainToRemovalSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "node_ttl_drain_to_removal_seconds",
+	Help:    "Time from a node being drained until it is removed from the cluster.",
+	Buckets: prometheus.ExponentialBuckets(30, 2, 12),
+})
+
+var drainedNodesPendingRemoval = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "node_ttl_drained_nodes_pending_removal",
+	Help: "Number of nodes which have been drained but not yet removed from the cluster.",
+})
+
+// NodeTerminator removes a node from the cluster after it has been drained.
+type NodeTerminator interface {
+	Terminate(ctx context.Context, node *corev1.Node) error
+}
+
+// DeleteNodeTerminator deletes the Node object. Removing the underlying instance is left to the cloud provider
+// integration of the cluster.
+type DeleteNodeTerminator struct {
+	client kubernetes.Interface
+}
+
+// NewDeleteNodeTerminator returns a terminator which deletes Node objects with the client.
+func NewDeleteNodeTerminator(client kubernetes.Interface) *DeleteNodeTerminator {
+	return &DeleteNodeTerminator{client: client}
+}
+
+// Terminate deletes the Node object, a node which has already been deleted is ignored.
+func (d *DeleteNodeTerminator) Terminate(ctx context.Context, node *corev1.Node) error {
+	// The precondition makes sure that a new node which reuses the name is not deleted.
+	uid := node.UID
+	err := d.client.CoreV1().Nodes().Delete(ctx, node.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// markDrained records when the node was drained. A node which is already marked keeps its original time.
+func markDrained(ctx context.Context, client kubernetes.Interface, nodeName string) error {
+	return k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if _, ok := node.Annotations[DrainedAtAnnotationKey]; ok {
+			return nil
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[DrainedAtAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+		_, err = client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// markTerminated records when the node was terminated. A node which has already been removed is ignored.
+func markTerminated(ctx context.Context, client kubernetes.Interface, nodeName string) error {
+	err := k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[TerminatedAtAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+		_, err = client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// drainedAt returns the time at which the node was drained, false is returned if the node has not been drained.
+func drainedAt(node *corev1.Node) (time.Time, bool) {
+	value, ok := node.Annotations[DrainedAtAnnotationKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// observeRemoval records the time from drain to removal when a drained node is deleted.
+func observeRemoval(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	drained, ok := drainedAt(node)
+	if !ok {
+		return
+	}
+	drainToRemovalSeconds.Observe(time.Since(drained).Seconds())
+}
+
+// recordPendingRemoval exposes the drained nodes which have not been removed, reporting nodes which remain after the
+// removal timeout once.
+func (c *controller) recordPendingRemoval(ctx context.Context, eval *evaluation) {
+	log := logr.FromContextOrDiscard(ctx)
+	drainedNodesPendingRemoval.Set(float64(len(eval.drained)))
+	timedOut := map[string]struct{}{}
+	for _, node := range eval.drained {
+		drained, ok := drainedAt(node)
+		if !ok || c.opts.RemovalTimeout <= 0 || time.Since(drained) < c.opts.RemovalTimeout {
+			continue
+		}
+		timedOut[node.Name] = struct{}{}
+		if _, ok := c.removalTimedOut[node.Name]; ok {
+			continue
+		}
+		log.Info("drained node has not been removed within the removal timeout", "node", node.Name, "drainedAt", drained)
+		c.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonTTLRemovalTimeout,
+			"Node was drained at %s but has not been removed within %s", drained.Format(time.RFC3339), c.opts.RemovalTimeout)
+	}
+	c.removalTimedOut = timedOut
+}
@@ -0,0 +1,159 @@
+package ttl
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakeNodeTerminator records the nodes it is asked to terminate without removing them.
+type fakeNodeTerminator struct {
+	mu         sync.Mutex
+	terminated []string
+}
+
+func (f *fakeNodeTerminator) Terminate(_ context.Context, node *corev1.Node) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.terminated = append(f.terminated, node.Name)
+	return nil
+}
+
+func (f *fakeNodeTerminator) terminatedNodes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.terminated...)
+}
+
+func TestDeleteNodeTerminator(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, true)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	terminator := NewDeleteNodeTerminator(client)
+	err := terminator.Terminate(ctx, node)
+	require.NoError(t, err)
+	_, err = client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+
+	// Terminating a node which has already been removed is not an error.
+	err = terminator.Terminate(ctx, node)
+	require.NoError(t, err)
+}
+
+func TestMarkDrained(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, true)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	err := markDrained(ctx, client, "node")
+	require.NoError(t, err)
+	drained, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.NoError(t, err)
+	first, ok := drainedAt(drained)
+	require.True(t, ok)
+
+	// Marking the node again keeps the original time.
+	err = markDrained(ctx, client, "node")
+	require.NoError(t, err)
+	drained, err = client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	require.NoError(t, err)
+	second, ok := drainedAt(drained)
+	require.True(t, ok)
+	require.True(t, first.Equal(second))
+}
+
+func TestObserveRemoval(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("node", &creationOffset, 1*time.Minute, true)
+	node.Annotations[DrainedAtAnnotationKey] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	undrained := testNodeWithTTL("undrained", &creationOffset, 1*time.Minute, false)
+
+	before := testHistogram(t, drainToRemovalSeconds)
+	observeRemoval(undrained)
+	observeRemoval(cache.DeletedFinalStateUnknown{Key: "node", Obj: node})
+	after := testHistogram(t, drainToRemovalSeconds)
+	require.Equal(t, before.GetSampleCount()+1, after.GetSampleCount())
+	require.GreaterOrEqual(t, after.GetSampleSum()-before.GetSampleSum(), 60.0)
+}
+
+func testHistogram(t *testing.T, histogram prometheus.Histogram) *dto.Histogram {
+	t.Helper()
+	metric := &dto.Metric{}
+	err := histogram.Write(metric)
+	require.NoError(t, err)
+	return metric.GetHistogram()
+}
+
+func TestDrainedPendingRemoval(t *testing.T) {
+	creationOffset := -10 * time.Hour
+	node := testNodeWithTTL("drained", &creationOffset, 1*time.Minute, true)
+	node.Annotations[DrainedAtAnnotationKey] = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	nodeCache := testNodeCache(t, client)
+	opts := Options{RemovalTimeout: time.Hour}
+	eval, err := evaluateNodes(ctx, nodeCache, opts, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Len(t, eval.drained, 1)
+
+	recorder := record.NewFakeRecorder(10)
+	c := &controller{opts: opts, recorder: recorder, removalTimedOut: map[string]struct{}{}}
+	c.recordPendingRemoval(ctx, eval)
+	require.InDelta(t, 1, testutil.ToFloat64(drainedNodesPendingRemoval), 0)
+	require.Len(t, recorder.Events, 1)
+	// The timeout is only reported once.
+	c.recordPendingRemoval(ctx, eval)
+	require.Len(t, recorder.Events, 1)
+
+	// Drained nodes are evicted again to retry the termination when a terminator is set.
+	opts.Terminator = &fakeNodeTerminator{}
+	eval, err = evaluateNodes(ctx, nodeCache, opts, nil)
+	require.NoError(t, err)
+	require.Len(t, eval.candidates, 1)
+}
+
+func TestTerminatedNodeIsNotTerminatedAgain(t *testing.T) {
+	creationOffset := -10 * time.Hour
+	node := testNodeWithTTL("drained", &creationOffset, 1*time.Minute, true)
+	node.Annotations[DrainedAtAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	terminator := &fakeNodeTerminator{}
+	opts := Options{Terminator: terminator}
+	c := &controller{client: client, opts: opts, recorder: record.NewFakeRecorder(10)}
+	err := c.terminate(ctx, node)
+	require.NoError(t, err)
+	require.Equal(t, []string{"drained"}, terminator.terminatedNodes())
+	terminated, err := client.CoreV1().Nodes().Get(ctx, "drained", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Contains(t, terminated.Annotations, TerminatedAtAnnotationKey)
+
+	// The terminated node is still drained but is no longer a candidate.
+	nodeCache := testNodeCache(t, client)
+	eval, err := evaluateNodes(ctx, nodeCache, opts, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Len(t, eval.drained, 1)
+
+	// Marking a node which has already been removed is not an error.
+	err = markTerminated(ctx, client, "removed")
+	require.NoError(t, err)
+}
@@ -3,19 +3,19 @@ package ttl
 import (
 	"context"
 	"fmt"
-	"io"
+	"sort"
+	"strconv"
 	"time"
 
-	"github.com/avast/retry-go"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/kubectl/pkg/drain"
+	"k8s.io/apimachinery/pkg/labels"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/xenitab/node-ttl/internal/config"
+	"github.com/xenitab/node-ttl/internal/duration"
+	"github.com/xenitab/node-ttl/internal/maintenance"
 	"github.com/xenitab/node-ttl/internal/status"
 )
 
@@ -29,22 +29,53 @@ var lastEvictionTimeSeconds = promauto.NewGauge(prometheus.GaugeOpts{
 	Help: "The date at which the last successful eviction occurred. Expressed as a Unix Epoch Time.",
 })
 
+var inMaintenanceWindowGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "node_ttl_in_maintenance_window",
+	Help: "Set to 1 if the global maintenance windows allow evictions to start, 0 otherwise.",
+})
+
+var dryRunCandidates = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_ttl_dry_run_candidates",
+	Help: "Nodes which would have been evicted if dry run was disabled.",
+}, []string{"node"})
+
+var dryRunSkippedNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "node_ttl_dry_run_skipped_nodes",
+	Help: "Nodes which were skipped during the last dry run evaluation, labeled by skip reason.",
+}, []string{"node", "reason"})
+
 const (
 	//nolint:staticcheck // ignore this
-	NodeTtlLabelKey      = "xkf.xenit.io/node-ttl"
-	ScaleDownDisabledKey = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
-	PodSafeToEvictKey    = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+	NodeTtlLabelKey                                   = "xkf.xenit.io/node-ttl"
+	ScaleDownDisabledKey                              = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+	PodSafeToEvictKey                                 = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+	NodePoolMaxConcurrentEvictionsLabelKey            = "xkf.xenit.io/node-ttl-pool-max-concurrent-evictions"
+	MaintenanceWindowAnnotationKey                    = "xkf.xenit.io/node-ttl-maintenance-window"
+	EvictionStartedAnnotationKey                      = "xkf.xenit.io/node-ttl-eviction-started"
+	EvictionOwnerAnnotationKey                        = "xkf.xenit.io/node-ttl-eviction-owner"
+	EvictionTaintKey                                  = "xkf.xenit.io/node-ttl-eviction"
+	EvictionBackoffAnnotationKey                      = "xkf.xenit.io/node-ttl-eviction-backoff-until"
+	JitterAnnotationKey                               = "xkf.xenit.io/node-ttl-jitter"
+	ExpiresAtAnnotationKey                            = "xkf.xenit.io/node-ttl-expires-at"
+	DrainedAtAnnotationKey                            = "xkf.xenit.io/node-ttl-drained-at"
+	TerminatedAtAnnotationKey                         = "xkf.xenit.io/node-ttl-terminated-at"
+	SurgeNodeAnnotationKey                            = "xkf.xenit.io/node-ttl-surge-for"
+	DrainForceAnnotationKey                           = "xkf.xenit.io/node-ttl-drain-force"
+	DrainGracePeriodSecondsAnnotationKey              = "xkf.xenit.io/node-ttl-drain-grace-period-seconds"
+	DrainDeleteEmptyDirDataAnnotationKey              = "xkf.xenit.io/node-ttl-drain-delete-emptydir-data"
+	DrainTimeoutAnnotationKey                         = "xkf.xenit.io/node-ttl-drain-timeout"
+	DrainPodSelectorAnnotationKey                     = "xkf.xenit.io/node-ttl-drain-pod-selector"
+	DrainDisableEvictionAnnotationKey                 = "xkf.xenit.io/node-ttl-drain-disable-eviction"
+	DrainSkipWaitForDeleteTimeoutSecondsAnnotationKey = "xkf.xenit.io/node-ttl-drain-skip-wait-for-delete-timeout-seconds"
 )
 
 // nodeContainsNotSafeToEvictPods checks if a node has any Pods which are not safe to evict.
-func nodeContainsNotSafeToEvictPods(ctx context.Context, client kubernetes.Interface, nodeName string) (bool, error) {
-	opts := metav1.ListOptions{FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName)}
-	podList, err := client.CoreV1().Pods("").List(ctx, opts)
+func nodeContainsNotSafeToEvictPods(nodeCache *nodeCache, nodeName string) (bool, error) {
+	pods, err := nodeCache.podsOnNode(nodeName)
 	if err != nil {
 		return false, err
 	}
-	for i := range podList.Items {
-		pod := podList.Items[i]
+	for _, pod := range pods {
 		//nolint:staticcheck // ignore this
 		if value, ok := pod.ObjectMeta.Annotations[PodSafeToEvictKey]; ok && value == "false" {
 			return true, nil
@@ -54,171 +85,606 @@ func nodeContainsNotSafeToEvictPods(ctx context.Context, client kubernetes.Inter
 }
 
 // nodeHasExpired returns true if node age is larger than ttl.
-func nodeHasExpired(node *corev1.Node) (bool, error) {
+func nodeHasExpired(node *corev1.Node, policy *nodePolicy, rules []config.TTLRule) (bool, error) {
+	expiry, err := nodeExpiry(node, policy, rules)
+	if err != nil {
+		return false, err
+	}
 	// Skip node which has not yet a creating timestamp
-	nullTime := time.Time{}
-	//nolint:staticcheck // ignore this
-	if node.CreationTimestamp.Time == nullTime {
+	if expiry.IsZero() {
 		return false, nil
 	}
+	return !time.Now().Before(expiry), nil
+}
+
+// nodeExpiry returns the time at which the node TTL expires. A zero time is returned if the node does not have a creation timestamp.
+// The expires at annotation overrides the TTL label which overrides the policy which overrides the first matching TTL
+// rule. The TTL is extended by a jitter derived from the node name when a jitter is set in the TTL or in the jitter
+// annotation, where the annotation has precedence.
+func nodeExpiry(node *corev1.Node, policy *nodePolicy, rules []config.TTLRule) (time.Time, error) {
+	if value, ok := node.Annotations[ExpiresAtAnnotationKey]; ok {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not parse expires at value: %s", value)
+		}
+		return expiresAt, nil
+	}
 	//nolint:staticcheck // ignore this
-	ttlValue, ok := node.ObjectMeta.Labels[NodeTtlLabelKey]
-	if !ok {
-		return false, fmt.Errorf("could not find ttl label in node: %s", NodeTtlLabelKey)
+	if node.CreationTimestamp.Time.IsZero() {
+		return time.Time{}, nil
 	}
-	ttlDuration, err := time.ParseDuration(ttlValue)
+	ttlDuration, jitter, err := nodeTTL(node, policy, rules)
 	if err != nil {
-		return false, fmt.Errorf("could not parse ttl value: %s", ttlValue)
+		return time.Time{}, err
 	}
-	diff := time.Since(node.CreationTimestamp.Time)
-	if diff < ttlDuration {
-		return false, nil
+	if value, ok := node.Annotations[JitterAnnotationKey]; ok {
+		jitter, err = duration.Parse(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not parse jitter value: %s", value)
+		}
+	}
+	return node.CreationTimestamp.Add(ttlDuration + duration.Jitter(node.Name, jitter)), nil
+}
+
+// nodeTTL returns the TTL and jitter of the node from the TTL label, the policy or the first matching TTL rule.
+func nodeTTL(node *corev1.Node, policy *nodePolicy, rules []config.TTLRule) (time.Duration, time.Duration, error) {
+	//nolint:staticcheck // ignore this
+	if ttlValue, ok := node.ObjectMeta.Labels[NodeTtlLabelKey]; ok {
+		ttlDuration, jitter, err := duration.ParseWithJitter(ttlValue)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not parse ttl value: %s", ttlValue)
+		}
+		return ttlDuration, jitter, nil
+	}
+	if policy != nil {
+		return policy.ttl, policy.jitter, nil
 	}
-	return true, nil
+	for _, rule := range rules {
+		if rule.Selector.Matches(labels.Set(node.Labels)) {
+			return rule.TTL, rule.Jitter, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("could not find ttl label in node: %s", NodeTtlLabelKey)
 }
 
-// ttlEvictionCandidate returns the most appropriate node to be evicted.
-// If the a node with expired TTL is being in progress of being evicted it will be returned.
+// hasTTL returns true if the node has a TTL label, a policy or matches any of the TTL rules.
+func hasTTL(node *corev1.Node, policy *nodePolicy, rules []config.TTLRule) bool {
+	if _, ok := node.Labels[NodeTtlLabelKey]; ok || policy != nil {
+		return true
+	}
+	for _, rule := range rules {
+		if rule.Selector.Matches(labels.Set(node.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipReason describes why an expired node was not considered a candidate for eviction.
+type SkipReason string
+
+const (
+	SkipReasonScaleDownDisabled        SkipReason = "ScaleDownDisabled"
+	SkipReasonInvalidTTL               SkipReason = "InvalidTTL"
+	SkipReasonNoScaleDownCapacity      SkipReason = "NoScaleDownCapacity"
+	SkipReasonNotSafeToEvictPods       SkipReason = "NotSafeToEvictPods"
+	SkipReasonNodePoolEvictionLimit    SkipReason = "NodePoolEvictionLimit"
+	SkipReasonOutsideMaintenanceWindow SkipReason = "OutsideMaintenanceWindow"
+	SkipReasonManuallyCordoned         SkipReason = "ManuallyCordoned"
+	SkipReasonDrainBackoff             SkipReason = "DrainBackoff"
+	SkipReasonPolicyEvictionLimit      SkipReason = "PolicyEvictionLimit"
+	SkipReasonInvalidDrainOptions      SkipReason = "InvalidDrainOptions"
+	SkipReasonStaleAutoscalerStatus    SkipReason = "StaleAutoscalerStatus"
+	SkipReasonAutoscalerUnhealthy      SkipReason = "AutoscalerUnhealthy"
+	SkipReasonNodePoolUnhealthy        SkipReason = "NodePoolUnhealthy"
+	SkipReasonNodePoolBackoff          SkipReason = "NodePoolBackoff"
+	SkipReasonScaleUpInProgress        SkipReason = "ScaleUpInProgress"
+	SkipReasonInsufficientResources    SkipReason = "InsufficientResources"
+)
+
+// evaluation is the result of evaluating all nodes with a TTL.
+type evaluation struct {
+	// candidates are the nodes which can be evicted, ordered with the most appropriate node first.
+	candidates []*corev1.Node
+	// expired contains all nodes with an expired TTL, including skipped nodes.
+	expired []*corev1.Node
+	// nodes contains all nodes with a TTL ordered by name.
+	nodes []*corev1.Node
+	// total is the number of nodes with a TTL.
+	total int
+	// skipped contains the skip reason for each node that was not considered a candidate.
+	skipped map[string]SkipReason
+	// expiries contains the expiry of each node with a TTL.
+	expiries map[string]time.Time
+	// drained contains the nodes which have been drained but not yet removed.
+	drained []*corev1.Node
+	// surge contains the candidates in node pools without capacity for scale down which need a new node before draining.
+	surge map[string]struct{}
+	// nodeGroups contains the cluster autoscaler node group of each new candidate, used to reserve scale down capacity.
+	nodeGroups map[string]*status.NodeGroupsType
+	// nodePolicies are all valid policies ordered by priority.
+	nodePolicies []*nodePolicy
+	// policies contains the policy applied to each node with a TTL which is matched by a policy.
+	policies map[string]*nodePolicy
+	// nextEvaluation is the earliest time at which the outcome of the evaluation is known to change, like when a node
+	// which has not yet expired will expire.
+	nextEvaluation time.Time
+}
+
+// scheduleEvaluation requests the next evaluation to happen no later than the given time.
+func (e *evaluation) scheduleEvaluation(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if e.nextEvaluation.IsZero() || t.Before(e.nextEvaluation) {
+		e.nextEvaluation = t
+	}
+}
+
+// evaluateNodes evaluates all nodes with a TTL, selecting the eviction candidates and recording why other nodes were skipped.
+// Nodes which are already being evicted are ordered first followed by the remaining candidates ordered by age.
 //
 //nolint:gocognit,cyclop //ignore
-func ttlEvictionCandidate(ctx context.Context, client kubernetes.Interface,
-	clusterAutoscalerStatus *types.NamespacedName) (*corev1.Node, bool, error) {
+func evaluateNodes(ctx context.Context, nodeCache *nodeCache, opts Options, inFlight map[string]struct{}) (*evaluation, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
 	// Get nodes with a set TTL value
-	nodeList, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: NodeTtlLabelKey})
+	cachedNodes, err := nodeCache.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, newEvaluationError(EvaluationErrorReasonListNodes, err)
+	}
+	policies, err := listPolicies(ctx, nodeCache, opts)
 	if err != nil {
-		return nil, false, err
+		return nil, newEvaluationError(EvaluationErrorReasonListPolicies, err)
+	}
+	eval := &evaluation{
+		skipped:      map[string]SkipReason{},
+		expiries:     map[string]time.Time{},
+		surge:        map[string]struct{}{},
+		nodeGroups:   map[string]*status.NodeGroupsType{},
+		nodePolicies: policies,
+		policies:     map[string]*nodePolicy{},
+	}
+	nodes := []*corev1.Node{}
+	for _, node := range cachedNodes {
+		policy := selectPolicy(policies, node)
+		if !hasTTL(node, policy, opts.TTLRules) {
+			continue
+		}
+		if policy != nil {
+			eval.policies[node.Name] = policy
+		}
+		nodes = append(nodes, node)
 	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
 
-	var candidate *corev1.Node
-	for i := range nodeList.Items {
-		node := nodeList.Items[i]
+	eval.nodes = nodes
+	eval.total = len(nodes)
+	var caStatus *status.ClusterAutoscalerStatus
+	// removing contains the number of ready nodes in each node group which are being removed.
+	var removing map[string]int
+	for _, node := range nodes {
 		log := log.WithValues("node", node.Name)
 
+		// Node has expired TTL
+		expiry, err := nodeExpiry(node, eval.policies[node.Name], opts.TTLRules)
+		if err != nil {
+			log.V(1).Info("skipping node that could not be determined if it is expired", "error", err.Error())
+			eval.skipped[node.Name] = SkipReasonInvalidTTL
+			continue
+		}
+		if expiry.IsZero() {
+			continue
+		}
+		eval.expiries[node.Name] = expiry
+		if time.Now().Before(expiry) {
+			eval.scheduleEvaluation(expiry)
+			continue
+		}
+		eval.expired = append(eval.expired, node)
+
+		// Drained nodes are waiting to be removed and are only evicted again to retry a failed termination
+		if drained, ok := drainedAt(node); ok && evictionInProgress(node, inFlight) {
+			eval.drained = append(eval.drained, node)
+			if opts.RemovalTimeout > 0 {
+				eval.scheduleEvaluation(drained.Add(opts.RemovalTimeout))
+			}
+			if _, terminated := node.Annotations[TerminatedAtAnnotationKey]; opts.Terminator != nil && !terminated {
+				eval.candidates = append(eval.candidates, node.DeepCopy())
+			}
+			continue
+		}
+
+		// Nodes which could not be drained in time are left alone until the backoff has passed
+		if until, ok := evictionBackoff(ctx, node); ok && time.Now().Before(until) {
+			log.V(1).Info("skipping node which is backing off after a drain timeout", "until", until)
+			eval.skipped[node.Name] = SkipReasonDrainBackoff
+			eval.scheduleEvaluation(until)
+			continue
+		}
+
+		// Drain annotations have to be valid for the node to be drained
+		if _, err := nodeDrainOptions(opts, eval.policies[node.Name], node); err != nil {
+			log.V(1).Info("skipping node with invalid drain options", "error", err.Error())
+			eval.skipped[node.Name] = SkipReasonInvalidDrainOptions
+			continue
+		}
+
 		// Scale down disabled annotation
 		//nolint:staticcheck // ignore this
 		if value, ok := node.ObjectMeta.Annotations[ScaleDownDisabledKey]; ok && value == "true" {
-			log.Info("skipping node with scale down disabled")
+			log.V(1).Info("skipping node with scale down disabled")
+			eval.skipped[node.Name] = SkipReasonScaleDownDisabled
 			continue
 		}
 
-		// Node has expired TTL
-		expired, err := nodeHasExpired(&node)
-		if err != nil {
-			log.Error(err, "skipping node that could not be determined if it is expired")
-			continue
-		}
-		if !expired {
+		// Nodes cordoned by someone else are left alone
+		if node.Spec.Unschedulable && !evictionInProgress(node, inFlight) {
+			log.V(1).Info("skipping node which has been cordoned manually")
+			eval.skipped[node.Name] = SkipReasonManuallyCordoned
 			continue
 		}
 
 		// Node pool has capacity to scale down
-		if clusterAutoscalerStatus != nil {
-			getOpts := metav1.GetOptions{}
-			caConfigMap, err := client.CoreV1().ConfigMaps(clusterAutoscalerStatus.Namespace).Get(ctx, clusterAutoscalerStatus.Name, getOpts)
+		var nodeGroup *status.NodeGroupsType
+		if opts.ClusterAutoscalerStatus != nil {
+			// The status is only read when needed as it does not exist until cluster autoscaler has started.
+			if caStatus == nil {
+				caStatus, err = nodeCache.clusterAutoscalerStatus(*opts.ClusterAutoscalerStatus)
+				if err != nil {
+					return nil, newEvaluationError(EvaluationErrorReasonClusterAutoscalerStatus, err)
+				}
+				removing = removingNodes(caStatus, cachedNodes, opts.nodePoolResolver(), inFlight)
+			}
+			nodeGroup, err = caStatus.NodeGroupOfNode(node, opts.nodePoolResolver())
 			if err != nil {
-				return nil, false, err
+				return nil, newEvaluationError(EvaluationErrorReasonNodePoolCapacity, err)
 			}
-			caStatus, ok := caConfigMap.Data["status"]
-			if !ok {
-				return nil, false, fmt.Errorf("could not find status in config map")
+			// New evictions are only started when cluster autoscaler is able to replace the node
+			reason, ok := clusterAutoscalerSkipReason(caStatus, nodeGroup, opts.ClusterAutoscalerStatusMaxAge, time.Now())
+			if ok && !evictionInProgress(node, inFlight) {
+				log.V(1).Info("skipping node because of the cluster autoscaler status", "reason", reason)
+				eval.skipped[node.Name] = reason
+				continue
 			}
-			ok, err = status.HasScaleDownCapacity(caStatus, &node)
-			if err != nil {
-				return nil, false, err
+			// Nodes being removed are still counted as ready by cluster autoscaler, so they are subtracted from the
+			// capacity. The headroom only applies to new evictions, as the node itself is already being removed.
+			nodeGroupRemoving := removing[nodeGroup.Name]
+			if nodeBeingRemoved(node, inFlight) {
+				nodeGroupRemoving--
+			}
+			headroom := opts.NodePoolHeadroom
+			if evictionInProgress(node, inFlight) {
+				headroom = 0
 			}
-			if !ok {
-				log.Info("skipping because node pool does not have capacity for scale down")
+			switch {
+			case nodeGroup.ScaleDownCapacity(nodeGroupRemoving) > headroom:
+			case opts.Surge && !evictionInProgress(node, inFlight):
+				log.V(1).Info("node pool does not have capacity for scale down, a new node will be added before draining")
+				eval.surge[node.Name] = struct{}{}
+			default:
+				log.V(1).Info("skipping because node pool does not have capacity for scale down")
+				eval.skipped[node.Name] = SkipReasonNoScaleDownCapacity
 				continue
 			}
 		}
 
 		// Pods in Nodes can't be evicted
-		containsNotSafeToEvict, err := nodeContainsNotSafeToEvictPods(ctx, client, node.Name)
+		containsNotSafeToEvict, err := nodeContainsNotSafeToEvictPods(nodeCache, node.Name)
 		if err != nil {
-			return nil, false, err
+			return nil, newEvaluationError(EvaluationErrorReasonListPods, err)
 		}
 		if containsNotSafeToEvict {
-			log.Info("skipping node containing pod marked not safe to evict")
+			log.V(1).Info("skipping node containing pod marked not safe to evict")
+			eval.skipped[node.Name] = SkipReasonNotSafeToEvictPods
 			continue
 		}
 
-		// We should return early with node if it is eligible for eviction and already unschedulable.
-		// TODO: Should there be a more specific way to determine eviction in progress?
-		if node.Spec.Unschedulable {
-			log.Info("continuing with node that is already being evicted")
-			return &node, true, nil
+		if evictionInProgress(node, inFlight) {
+			log.V(1).Info("continuing with node that is already being evicted")
+			eval.candidates = append(eval.candidates, node.DeepCopy())
+			continue
 		}
-		// Skip node if current candidate is older.
-		if candidate != nil && node.CreationTimestamp.After(candidate.CreationTimestamp.Time) {
+
+		// New evictions are only started within a maintenance window
+		if ok, nextStart := inMaintenanceWindow(ctx, node, eval.policies[node.Name], opts, time.Now()); !ok {
+			log.V(1).Info("skipping node outside of maintenance window")
+			eval.skipped[node.Name] = SkipReasonOutsideMaintenanceWindow
+			eval.scheduleEvaluation(nextStart)
 			continue
 		}
-		candidate = &node
+
+		if nodeGroup != nil {
+			eval.nodeGroups[node.Name] = nodeGroup
+		}
+		eval.candidates = append(eval.candidates, node.DeepCopy())
 	}
-	if candidate == nil {
-		return nil, false, nil
+	sort.SliceStable(eval.candidates, func(i, j int) bool {
+		a, b := eval.candidates[i], eval.candidates[j]
+		aInProgress, bInProgress := evictionInProgress(a, inFlight), evictionInProgress(b, inFlight)
+		if aInProgress != bInProgress {
+			return aInProgress
+		}
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	})
+	var simulation *drainSimulation
+	if opts.ResourceCheck {
+		simulation = newDrainSimulation(nodeCache, opts.nodePoolResolver(), inFlight)
 	}
-	return candidate, true, nil
+	eval.candidates, err = selectCandidates(ctx, eval, nodes, opts, inFlight, removing, simulation)
+	if err != nil {
+		return nil, err
+	}
+	return eval, nil
 }
 
-// evictNode cordons and drains the specified node.
-func evictNode(ctx context.Context, client kubernetes.Interface, node *corev1.Node) error {
+// selectCandidates removes new candidates which exceed the concurrent evictions of their node pool or policy, the
+// scale down capacity of their node group or whose Pods do not fit on the other nodes in the node pool when the
+// simulation is set. Candidates are selected in order and only selected candidates count towards the limits and
+// reserve capacity and resources, so that a candidate which is removed does not block later candidates. Candidates
+// beyond the eviction budget are last in order and cannot block the candidates within it. Candidates already being
+// evicted are always kept.
+func selectCandidates(ctx context.Context, eval *evaluation, nodes []*corev1.Node, opts Options, inFlight map[string]struct{},
+	removing map[string]int, simulation *drainSimulation) ([]*corev1.Node, error) {
 	log := logr.FromContextOrDiscard(ctx)
-	helper := &drain.Helper{
-		Ctx:                 ctx,
-		Client:              client,
-		Force:               true, // Evict orphaned DaemonSet Pods and Pods with a controller
-		GracePeriodSeconds:  -1,   // Respect Pod termination grace period.
-		IgnoreAllDaemonSets: true,
-		DeleteEmptyDirData:  true,
-		ErrOut:              io.Discard,
-		Out:                 io.Discard,
-		OnPodDeletedOrEvicted: func(pod *corev1.Pod, usingEviction bool) {
-			log.Info("completed eviction", "pod", pod.Name)
-		},
-	}
-
-	// Retry to avoid large delays when API server hickups occur.
-	err := retry.Do(func() error {
-		err := drain.RunCordonOrUncordon(helper, node, true)
+
+	if simulation != nil {
+		err := reserveInProgress(eval, nodes, opts, inFlight, simulation)
 		if err != nil {
-			return fmt.Errorf("could not cordon node %s: %w", node.Name, err)
+			return nil, newEvaluationError(EvaluationErrorReasonResourceCheck, err)
 		}
-		err = drain.RunNodeDrain(helper, node.Name)
-		if err != nil {
-			return fmt.Errorf("could not drain node %s: %w", node.Name, err)
+	}
+	nodePoolEvicting := nodePoolEvictions(eval, nodes, opts, inFlight)
+	policyEvicting := policyEvictions(eval, nodes, inFlight)
+	candidates := []*corev1.Node{}
+	for _, node := range eval.candidates {
+		if evictionInProgress(node, inFlight) {
+			candidates = append(candidates, node)
+			continue
 		}
-		// Wait for node to be deleted
-		return nil
-	}, retry.OnRetry(func(n uint, err error) {
-		log.Error(err, "retrying drain due to error", "attempt", n)
-	}), retry.Attempts(5), retry.Delay(1*time.Second))
+		nodePool, nodePoolErr := opts.nodePoolResolver().NodePoolName(node)
+		if nodePoolErr == nil && nodePoolLimitReached(ctx, node, nodePool, nodePoolEvicting, opts) {
+			log.V(1).Info("skipping node because node pool eviction limit is reached", "node", node.Name, "nodePool", nodePool)
+			eval.skipped[node.Name] = SkipReasonNodePoolEvictionLimit
+			continue
+		}
+		policy := eval.policies[node.Name]
+		if policy != nil && policy.maxConcurrentEvictions > 0 && policyEvicting[policy.name] >= policy.maxConcurrentEvictions {
+			log.V(1).Info("skipping node because policy eviction limit is reached", "node", node.Name, "policy", policy.name)
+			eval.skipped[node.Name] = SkipReasonPolicyEvictionLimit
+			continue
+		}
+		// Capacity reserved by earlier candidates may leave none for this node.
+		nodeGroup := eval.nodeGroups[node.Name]
+		_, surge := eval.surge[node.Name]
+		if nodeGroup != nil && !surge && nodeGroup.ScaleDownCapacity(removing[nodeGroup.Name]) <= opts.NodePoolHeadroom {
+			if !opts.Surge {
+				log.V(1).Info("skipping because node pool does not have capacity for scale down", "node", node.Name)
+				eval.skipped[node.Name] = SkipReasonNoScaleDownCapacity
+				continue
+			}
+			log.V(1).Info("node pool does not have capacity for scale down, a new node will be added before draining", "node", node.Name)
+			eval.surge[node.Name] = struct{}{}
+			surge = true
+		}
+		// Pods have to fit on the other nodes in the node pool, unless a new node is added before draining. This is
+		// checked last as the simulation reserves the resources on the other nodes.
+		if simulation != nil && !surge {
+			drainOpts, err := nodeDrainOptions(opts, policy, node)
+			if err != nil {
+				return nil, newEvaluationError(EvaluationErrorReasonResourceCheck, err)
+			}
+			pod, err := simulation.drain(node, drainOpts.podSelector)
+			if err != nil {
+				return nil, newEvaluationError(EvaluationErrorReasonResourceCheck, err)
+			}
+			if pod != nil {
+				log.V(1).Info("skipping node because its pods do not fit on the other nodes in the node pool",
+					"node", node.Name, "namespace", pod.Namespace, "pod", pod.Name)
+				eval.skipped[node.Name] = SkipReasonInsufficientResources
+				continue
+			}
+		}
+		if nodePoolErr == nil {
+			nodePoolEvicting[nodePool]++
+		}
+		if policy != nil {
+			policyEvicting[policy.name]++
+		}
+		// The capacity is reserved so that later nodes in the same node group do not use it.
+		if nodeGroup != nil && isNodeReady(node) {
+			removing[nodeGroup.Name]++
+		}
+		candidates = append(candidates, node)
+	}
+	return candidates, nil
+}
+
+// reserveInProgress reserves the resources needed by Pods which are waiting to be scheduled and by the Pods of nodes
+// which are already being evicted, so that new candidates are only drained when their Pods fit in what is left.
+func reserveInProgress(eval *evaluation, nodes []*corev1.Node, opts Options, inFlight map[string]struct{},
+	simulation *drainSimulation) error {
+	err := simulation.reservePending()
 	if err != nil {
 		return err
 	}
+	for _, node := range nodes {
+		if !evictionInProgress(node, inFlight) {
+			continue
+		}
+		// All Pods are reserved when the drain options of the node are invalid.
+		podSelector := ""
+		if drainOpts, err := nodeDrainOptions(opts, eval.policies[node.Name], node); err == nil {
+			podSelector = drainOpts.podSelector
+		}
+		err = simulation.reserveEviction(node, podSelector)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// evictNextExpiredNode will attempt to evict the next expired node if one exists.
-func evictNextExpiredNode(ctx context.Context, client kubernetes.Interface, clusterAutoscalerStatus *types.NamespacedName) error {
-	log := logr.FromContextOrDiscard(ctx)
-	log.Info("checking for node with expired ttl")
-	node, ok, err := ttlEvictionCandidate(ctx, client, clusterAutoscalerStatus)
-	if err != nil {
-		return err
+// clusterAutoscalerSkipReason returns the reason new evictions should not start according to the cluster autoscaler
+// status. A status which is older than the max age is not trusted, zero meaning no max age. Health which is not
+// set is considered healthy as it is not reported by all versions of cluster autoscaler.
+func clusterAutoscalerSkipReason(caStatus *status.ClusterAutoscalerStatus, nodeGroup *status.NodeGroupsType, maxAge time.Duration,
+	now time.Time) (SkipReason, bool) {
+	clusterWide := caStatus.ClusterWide
+	switch {
+	case maxAge > 0 && !caStatus.Time.IsZero() && now.Sub(caStatus.Time) > maxAge:
+		return SkipReasonStaleAutoscalerStatus, true
+	case !caStatus.IsRunning(), clusterWide.Health.Status != "" && !clusterWide.Health.IsHealthy():
+		return SkipReasonAutoscalerUnhealthy, true
+	case nodeGroup.Health.Status != "" && !nodeGroup.Health.IsHealthy(), nodeGroup.Health.HasUnregisteredNodes():
+		return SkipReasonNodePoolUnhealthy, true
+	case nodeGroup.ScaleUp.Status == status.ScaleUpStatusBackoff:
+		return SkipReasonNodePoolBackoff, true
+	case clusterWide.ScaleUp.Status == status.ScaleUpStatusInProgress, nodeGroup.ScaleUp.Status == status.ScaleUpStatusInProgress:
+		return SkipReasonScaleUpInProgress, true
+	default:
+		return "", false
 	}
+}
+
+// removingNodes returns the number of nodes in each node group which are counted as ready by cluster autoscaler while
+// they are being removed, either as they are being evicted or have been cordoned.
+func removingNodes(caStatus *status.ClusterAutoscalerStatus, nodes []*corev1.Node, resolver status.NodePoolResolver,
+	inFlight map[string]struct{}) map[string]int {
+	removing := map[string]int{}
+	for _, node := range nodes {
+		if !nodeBeingRemoved(node, inFlight) {
+			continue
+		}
+		nodeGroup, err := caStatus.NodeGroupOfNode(node, resolver)
+		if err != nil {
+			continue
+		}
+		removing[nodeGroup.Name]++
+	}
+	return removing
+}
+
+// nodeBeingRemoved returns true if the node is ready but is cordoned or being evicted.
+func nodeBeingRemoved(node *corev1.Node, inFlight map[string]struct{}) bool {
+	return isNodeReady(node) && (node.Spec.Unschedulable || evictionInProgress(node, inFlight))
+}
+
+// inMaintenanceWindow returns true if evictions may start for the node at the given time. If not the time at which
+// the next maintenance window starts is returned. The node annotation overrides the policy maintenance windows which
+// override the global maintenance windows.
+func inMaintenanceWindow(ctx context.Context, node *corev1.Node, policy *nodePolicy, opts Options, now time.Time) (bool, time.Time) {
+	windows := opts.MaintenanceWindows
+	if policy != nil && policy.maintenanceWindows != nil {
+		windows = policy.maintenanceWindows
+	}
+	if value, ok := node.Annotations[MaintenanceWindowAnnotationKey]; ok {
+		nodeWindows, err := maintenance.ParseList(value, opts.MaintenanceWindowLocation)
+		if err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "ignoring invalid maintenance window annotation", "node", node.Name)
+		} else {
+			windows = nodeWindows
+		}
+	}
+	if maintenance.Contains(windows, now) {
+		return true, time.Time{}
+	}
+	return false, maintenance.NextStart(windows, now)
+}
+
+// evictionInProgress returns true if the node is already being evicted by node ttl. Nodes which are only cordoned
+// are not considered to be evicted as they may have been cordoned by someone else.
+func evictionInProgress(node *corev1.Node, inFlight map[string]struct{}) bool {
+	if _, ok := inFlight[node.Name]; ok {
+		return true
+	}
+	_, ok := node.Annotations[EvictionStartedAnnotationKey]
+	return ok
+}
+
+// evictionBackoff returns the time until which new evictions of the node should not be started.
+func evictionBackoff(ctx context.Context, node *corev1.Node) (time.Time, bool) {
+	value, ok := node.Annotations[EvictionBackoffAnnotationKey]
 	if !ok {
-		log.Info("no node with expired ttl found")
-		return nil
+		return time.Time{}, false
 	}
-	log.Info("evicting node with expired ttl", "node", node.Name)
-	err = evictNode(ctx, client, node)
+	until, err := time.Parse(time.RFC3339, value)
 	if err != nil {
-		return err
+		logr.FromContextOrDiscard(ctx).Error(err, "ignoring invalid eviction backoff annotation", "node", node.Name)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// nodePoolEvictions returns the number of evictions in progress for each node pool, including nodes that were skipped.
+func nodePoolEvictions(eval *evaluation, nodes []*corev1.Node, opts Options, inFlight map[string]struct{}) map[string]int {
+	evicting := map[string]int{}
+	for _, node := range nodes {
+		if !evictionInProgress(node, inFlight) {
+			continue
+		}
+		if expired, err := nodeHasExpired(node, eval.policies[node.Name], opts.TTLRules); err != nil || !expired {
+			continue
+		}
+		nodePool, err := opts.nodePoolResolver().NodePoolName(node)
+		if err != nil {
+			continue
+		}
+		evicting[nodePool]++
+	}
+	return evicting
+}
+
+// nodePoolLimitReached returns true if the node pool of the node is at its limit of concurrent evictions.
+func nodePoolLimitReached(ctx context.Context, node *corev1.Node, nodePool string, evicting map[string]int, opts Options) bool {
+	limit, err := nodePoolEvictionLimit(node, nodePool, opts)
+	if err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "ignoring invalid node pool eviction limit", "node", node.Name)
+		limit = opts.MaxConcurrentEvictionsPerNodePool
+	}
+	return limit > 0 && evicting[nodePool] >= limit
+}
+
+// nodePoolEvictionLimit returns the maximum concurrent evictions in the node pool, zero meaning no limit.
+// The node label has precedence over the node pool override which has precedence over the default.
+func nodePoolEvictionLimit(node *corev1.Node, nodePool string, opts Options) (int, error) {
+	if value, ok := node.Labels[NodePoolMaxConcurrentEvictionsLabelKey]; ok {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse node pool max concurrent evictions value: %s", value)
+		}
+		return limit, nil
+	}
+	if limit, ok := opts.NodePoolMaxConcurrentEvictions[nodePool]; ok {
+		return limit, nil
+	}
+	return opts.MaxConcurrentEvictionsPerNodePool, nil
+}
+
+// reportDryRun logs and exposes metrics for what would have been evicted. Everything is logged at the info level as
+// reporting the outcome is the purpose of a dry run.
+func reportDryRun(ctx context.Context, eval *evaluation, candidates []*corev1.Node) {
+	log := logr.FromContextOrDiscard(ctx)
+	dryRunCandidates.Reset()
+	dryRunSkippedNodes.Reset()
+	skipped := make([]string, 0, len(eval.skipped))
+	for nodeName := range eval.skipped {
+		skipped = append(skipped, nodeName)
+	}
+	sort.Strings(skipped)
+	for _, nodeName := range skipped {
+		reason := eval.skipped[nodeName]
+		log.Info("dry run skipped node", "node", nodeName, "reason", reason)
+		dryRunSkippedNodes.WithLabelValues(nodeName, string(reason)).Set(1)
+	}
+	if len(candidates) == 0 {
+		log.Info("dry run found no node with expired ttl")
+		return
+	}
+	for _, node := range candidates {
+		log.Info("dry run would evict node with expired ttl", "node", node.Name)
+		dryRunCandidates.WithLabelValues(node.Name).Set(1)
 	}
-	log.Info("eviction complete", "node", node.Name)
-	evictedNodesTotal.Inc()
-	lastEvictionTimeSeconds.Set(float64(time.Now().Unix()))
-	return nil
 }
@@ -2,21 +2,44 @@ package ttl
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/xenitab/node-ttl/internal/config"
+	"github.com/xenitab/node-ttl/internal/status"
 )
 
+func testNodeCache(t *testing.T, client kubernetes.Interface) *nodeCache {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	t.Cleanup(cancel)
+	nodeCache, err := newNodeCache(ctx, client, NodeTtlLabelKey, nil)
+	require.NoError(t, err)
+	return nodeCache
+}
+
 func testNodeWithTTL(name string, creationOffest *time.Duration, ttl time.Duration, evicting bool) *corev1.Node {
 	var creationTimestamp *metav1.Time
 	if creationOffest != nil {
 		creationTimestamp = &metav1.Time{Time: time.Now().Add(*creationOffest)}
 	}
-	return &corev1.Node{
+	node := &corev1.Node{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
 			Labels: map[string]string{
@@ -28,6 +51,14 @@ func testNodeWithTTL(name string, creationOffest *time.Duration, ttl time.Durati
 			Unschedulable: evicting,
 		},
 	}
+	if evicting {
+		node.Annotations = map[string]string{
+			EvictionStartedAnnotationKey: time.Now().Format(time.RFC3339),
+			EvictionOwnerAnnotationKey:   "test",
+		}
+		node.Spec.Taints = []corev1.Taint{{Key: EvictionTaintKey, Effect: corev1.TaintEffectNoSchedule}}
+	}
+	return node
 }
 
 func TestExpiredTtl(t *testing.T) {
@@ -114,10 +145,10 @@ func TestExpiredTtl(t *testing.T) {
 				_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
 				require.NoError(t, err)
 			}
-			node, ok, err := ttlEvictionCandidate(ctx, client, nil)
+			eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
 			require.NoError(t, err)
-			require.True(t, ok)
-			require.Equal(t, tt.nodeName, node.Name)
+			require.NotEmpty(t, eval.candidates)
+			require.Equal(t, tt.nodeName, eval.candidates[0].Name)
 		})
 	}
 }
@@ -140,9 +171,53 @@ func TestScaleDownDisabled(t *testing.T) {
 	client := fake.NewSimpleClientset()
 	_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
 	require.NoError(t, err)
-	_, ok, err := ttlEvictionCandidate(ctx, client, nil)
-	require.Nil(t, err)
-	require.False(t, ok)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Equal(t, SkipReasonScaleDownDisabled, eval.skipped["scale-down-disabled"])
+}
+
+func TestManuallyCordoned(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	cordoned := testNodeWithTTL("cordoned", &creationOffset, 1*time.Minute, false)
+	cordoned.Spec.Unschedulable = true
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset()
+	_, err := client.CoreV1().Nodes().Create(ctx, cordoned, metav1.CreateOptions{})
+	require.NoError(t, err)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Equal(t, SkipReasonManuallyCordoned, eval.skipped["cordoned"])
+}
+
+func TestDrainBackoff(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("backoff", &creationOffset, 1*time.Minute, false)
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	node.Annotations = map[string]string{EvictionBackoffAnnotationKey: until.Format(time.RFC3339)}
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Equal(t, SkipReasonDrainBackoff, eval.skipped["backoff"])
+	require.True(t, until.Equal(eval.nextEvaluation))
+}
+
+func TestInvalidDrainOptions(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("invalid", &creationOffset, 1*time.Minute, false)
+	node.Annotations = map[string]string{DrainForceAnnotationKey: "maybe"}
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset(node)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Equal(t, SkipReasonInvalidDrainOptions, eval.skipped["invalid"])
 }
 
 func TestInvalidTtlLabelValue(t *testing.T) {
@@ -160,9 +235,10 @@ func TestInvalidTtlLabelValue(t *testing.T) {
 	client := fake.NewSimpleClientset()
 	_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
 	require.NoError(t, err)
-	_, ok, err := ttlEvictionCandidate(ctx, client, nil)
-	require.Nil(t, err)
-	require.False(t, ok)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Equal(t, SkipReasonInvalidTTL, eval.skipped["invalid"])
 }
 
 func TestMissingCreationTimestamp(t *testing.T) {
@@ -179,14 +255,69 @@ func TestMissingCreationTimestamp(t *testing.T) {
 	client := fake.NewSimpleClientset()
 	_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
 	require.NoError(t, err)
-	node, ok, err := ttlEvictionCandidate(ctx, client, nil)
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
 	require.NoError(t, err)
-	require.False(t, ok)
-	require.Nil(t, node)
+	require.Empty(t, eval.candidates)
+}
+
+func TestNodeExpiry(t *testing.T) {
+	created := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node",
+			Labels:            map[string]string{NodeTtlLabelKey: "7d"},
+			CreationTimestamp: metav1.Time{Time: created},
+		},
+	}
+	expiry, err := nodeExpiry(node, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, created.Add(7*24*time.Hour), expiry)
+
+	node.Annotations = map[string]string{JitterAnnotationKey: "12h"}
+	jittered, err := nodeExpiry(node, nil, nil)
+	require.NoError(t, err)
+	require.False(t, jittered.Before(expiry))
+	require.True(t, jittered.Before(expiry.Add(12*time.Hour)))
+	again, err := nodeExpiry(node, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, jittered, again)
+
+	node.Annotations[ExpiresAtAnnotationKey] = "2025-04-03T00:00:00Z"
+	expiry, err = nodeExpiry(node, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2025, 4, 3, 0, 0, 0, 0, time.UTC), expiry.UTC())
+
+	node.Annotations[ExpiresAtAnnotationKey] = "tomorrow"
+	_, err = nodeExpiry(node, nil, nil)
+	require.Error(t, err)
+}
+
+func TestTTLRules(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	labeled := testNodeWithTTL("labeled", &creationOffset, 1*time.Hour, false)
+	labeled.Labels[status.AzureNodePoolLabelKey] = "system"
+	matched := testNodeWithTTL("matched", &creationOffset, 0, false)
+	matched.Labels = map[string]string{status.AzureNodePoolLabelKey: "system"}
+	unmatched := testNodeWithTTL("unmatched", &creationOffset, 0, false)
+	unmatched.Labels = map[string]string{status.AzureNodePoolLabelKey: "user"}
+	rules := []config.TTLRule{
+		{Selector: labels.SelectorFromSet(labels.Set{status.AzureNodePoolLabelKey: "system"}), TTL: 1 * time.Minute},
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(labeled, matched, unmatched)
+	nodeCache, err := newNodeCache(ctx, client, "", nil)
+	require.NoError(t, err)
+	eval, err := evaluateNodes(ctx, nodeCache, Options{TTLRules: rules}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, eval.total)
+	require.Len(t, eval.candidates, 1)
+	require.Equal(t, "matched", eval.candidates[0].Name)
 }
 
 func TestNodeContainsNotSafeToEvict(t *testing.T) {
-	nodeName := ""
+	nodeName := "node"
 	pods := []corev1.Pod{
 		{
 			ObjectMeta: metav1.ObjectMeta{
@@ -224,7 +355,461 @@ func TestNodeContainsNotSafeToEvict(t *testing.T) {
 		require.NoError(t, err)
 	}
 
-	result, err := nodeContainsNotSafeToEvictPods(ctx, client, nodeName)
+	result, err := nodeContainsNotSafeToEvictPods(testNodeCache(t, client), nodeName)
 	require.NoError(t, err)
 	require.True(t, result)
 }
+
+func TestDryRun(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	nodes := []*corev1.Node{
+		testNodeWithTTL("expired", &creationOffset, 1*time.Minute, false),
+		testNodeWithTTL("scale-down-disabled", &creationOffset, 1*time.Minute, false),
+	}
+	nodes[1].Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+
+	// Only info level logs are captured, as used in production.
+	var mu sync.Mutex
+	logs := []string{}
+	log := funcr.New(func(prefix, args string) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, args)
+	}, funcr.Options{Verbosity: 0})
+	ctx, cancel := context.WithCancel(logr.NewContext(context.TODO(), log))
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	c, err := newController(ctx, client, Options{DryRun: true, MaxConcurrentEvictions: intstr.FromInt32(1)})
+	require.NoError(t, err)
+	err = c.reconcile(ctx)
+	require.NoError(t, err)
+	node, err := client.CoreV1().Nodes().Get(ctx, "expired", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, node.Spec.Unschedulable)
+	require.InDelta(t, 1, testutil.ToFloat64(dryRunCandidates.WithLabelValues("expired")), 0)
+	skipped := dryRunSkippedNodes.WithLabelValues("scale-down-disabled", string(SkipReasonScaleDownDisabled))
+	require.InDelta(t, 1, testutil.ToFloat64(skipped), 0)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, logs, `"level"=0 "msg"="dry run would evict node with expired ttl" "node"="expired"`)
+	require.Contains(t, logs, `"level"=0 "msg"="dry run skipped node" "node"="scale-down-disabled" "reason"="ScaleDownDisabled"`)
+}
+
+func TestNoScaleDownCapacity(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("foo", &creationOffset, 1*time.Minute, false)
+	node.Labels[status.KubemarkNodePoolLabelKey] = "foo"
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-autoscaler-status",
+			Namespace: "cluster-autoscaler",
+		},
+		Data: map[string]string{
+			"status": `nodeGroups:
+- name: foo
+  health:
+    status: Healthy
+    nodeCounts:
+      registered:
+        total: 1
+        ready: 1
+    minSize: 1
+    maxSize: 3`,
+		},
+	}
+	clusterAutoscalerStatus := &types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(node, configMap)
+	nodeCache, err := newNodeCache(ctx, client, NodeTtlLabelKey, clusterAutoscalerStatus)
+	require.NoError(t, err)
+	eval, err := evaluateNodes(ctx, nodeCache, Options{ClusterAutoscalerStatus: clusterAutoscalerStatus}, nil)
+	require.NoError(t, err)
+	require.Empty(t, eval.candidates)
+	require.Equal(t, SkipReasonNoScaleDownCapacity, eval.skipped["foo"])
+
+	// With surge the node is a candidate which needs a new node before it is drained.
+	eval, err = evaluateNodes(ctx, nodeCache, Options{ClusterAutoscalerStatus: clusterAutoscalerStatus, Surge: true}, nil)
+	require.NoError(t, err)
+	require.Len(t, eval.candidates, 1)
+	require.Contains(t, eval.surge, "foo")
+}
+
+func TestScaleDownCapacityRemovingNodes(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	readyNode := func(name string, evicting, cordoned bool) *corev1.Node {
+		node := testNodeWithTTL(name, &creationOffset, 1*time.Minute, evicting)
+		node.Labels[status.KubemarkNodePoolLabelKey] = "foo"
+		node.Spec.Unschedulable = node.Spec.Unschedulable || cordoned
+		node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+		return node
+	}
+	statusConfigMap := func(ready int) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cluster-autoscaler-status",
+				Namespace: "cluster-autoscaler",
+			},
+			Data: map[string]string{
+				"status": fmt.Sprintf(`nodeGroups:
+- name: foo
+  health:
+    status: Healthy
+    nodeCounts:
+      registered:
+        total: %[1]d
+        ready: %[1]d
+    minSize: 1
+    maxSize: 5`, ready),
+			},
+		}
+	}
+	clusterAutoscalerStatus := &types.NamespacedName{Namespace: "cluster-autoscaler", Name: "cluster-autoscaler-status"}
+
+	tests := []struct {
+		name       string
+		nodes      []*corev1.Node
+		ready      int
+		headroom   int
+		candidates []string
+		skipped    []string
+	}{
+		{
+			name:       "evicting and cordoned nodes are subtracted",
+			nodes:      []*corev1.Node{readyNode("a", true, false), readyNode("b", false, true), readyNode("c", false, false)},
+			ready:      3,
+			candidates: []string{"a"},
+			skipped:    []string{"c"},
+		},
+		{
+			name:       "evicting node does not count against itself",
+			nodes:      []*corev1.Node{readyNode("a", true, false)},
+			ready:      2,
+			headroom:   2,
+			candidates: []string{"a"},
+		},
+		{
+			name:       "capacity left after evicting node",
+			nodes:      []*corev1.Node{readyNode("a", true, false), readyNode("c", false, false)},
+			ready:      3,
+			candidates: []string{"a", "c"},
+		},
+		{
+			name:       "headroom",
+			nodes:      []*corev1.Node{readyNode("a", true, false), readyNode("c", false, false)},
+			ready:      3,
+			headroom:   1,
+			candidates: []string{"a"},
+			skipped:    []string{"c"},
+		},
+		{
+			name:       "candidates reserve capacity",
+			nodes:      []*corev1.Node{readyNode("c", false, false), readyNode("d", false, false)},
+			ready:      2,
+			candidates: []string{"c"},
+			skipped:    []string{"d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{statusConfigMap(tt.ready)}
+			for _, node := range tt.nodes {
+				objects = append(objects, node)
+			}
+			client := fake.NewSimpleClientset(objects...)
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			nodeCache, err := newNodeCache(ctx, client, NodeTtlLabelKey, clusterAutoscalerStatus)
+			require.NoError(t, err)
+			opts := Options{ClusterAutoscalerStatus: clusterAutoscalerStatus, NodePoolHeadroom: tt.headroom}
+			eval, err := evaluateNodes(ctx, nodeCache, opts, nil)
+			require.NoError(t, err)
+			candidates := []string{}
+			for _, node := range eval.candidates {
+				candidates = append(candidates, node.Name)
+			}
+			require.ElementsMatch(t, tt.candidates, candidates)
+			for _, name := range tt.skipped {
+				require.Equal(t, SkipReasonNoScaleDownCapacity, eval.skipped[name], name)
+			}
+		})
+	}
+}
+
+func TestSelectCandidatesReservesCapacityForSelectedNodes(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	readyNode := func(name string, evicting bool) *corev1.Node {
+		node := testNodeWithTTL(name, &creationOffset, 1*time.Minute, evicting)
+		node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+		return node
+	}
+	nodes := []*corev1.Node{readyNode("a", true), readyNode("c", false), readyNode("d", false)}
+	nodeGroup := &status.NodeGroupsType{Name: "foo"}
+	nodeGroup.Health.MinSize = 1
+	nodeGroup.Health.NodeCounts.Registered.Ready = 3
+	policy := &nodePolicy{name: "policy", maxConcurrentEvictions: 1}
+	eval := &evaluation{
+		candidates: nodes,
+		skipped:    map[string]SkipReason{},
+		surge:      map[string]struct{}{},
+		nodeGroups: map[string]*status.NodeGroupsType{"c": nodeGroup, "d": nodeGroup},
+		policies:   map[string]*nodePolicy{"a": policy, "c": policy},
+	}
+
+	// The node removed by the policy limit does not reserve the capacity left after the node being evicted.
+	candidates, err := selectCandidates(context.TODO(), eval, nodes, Options{}, nil, map[string]int{"foo": 1}, nil)
+	require.NoError(t, err)
+	names := []string{}
+	for _, node := range candidates {
+		names = append(names, node.Name)
+	}
+	require.Equal(t, []string{"a", "d"}, names)
+	require.Equal(t, SkipReasonPolicyEvictionLimit, eval.skipped["c"])
+}
+
+func TestClusterAutoscalerSkipReason(t *testing.T) {
+	now := time.Now()
+	healthy := func() (*status.ClusterAutoscalerStatus, *status.NodeGroupsType) {
+		nodeGroup := &status.NodeGroupsType{
+			Name:    "foo",
+			Health:  status.HealthType{Status: status.HealthStatusHealthy},
+			ScaleUp: status.ScaleUpType{Status: "NoActivity"},
+		}
+		caStatus := &status.ClusterAutoscalerStatus{
+			Time:             now.Add(-10 * time.Second),
+			AutoscalerStatus: status.AutoscalerStatusRunning,
+			ClusterWide: status.ClusterWideType{
+				Health:  status.HealthType{Status: status.HealthStatusHealthy},
+				ScaleUp: status.ScaleUpType{Status: "NoActivity"},
+			},
+			NodeGroups: []*status.NodeGroupsType{nodeGroup},
+		}
+		return caStatus, nodeGroup
+	}
+	tests := []struct {
+		name     string
+		modify   func(*status.ClusterAutoscalerStatus, *status.NodeGroupsType)
+		expected SkipReason
+	}{
+		{
+			name:   "healthy",
+			modify: func(*status.ClusterAutoscalerStatus, *status.NodeGroupsType) {},
+		},
+		{
+			name: "health not reported",
+			modify: func(caStatus *status.ClusterAutoscalerStatus, nodeGroup *status.NodeGroupsType) {
+				caStatus.Time = time.Time{}
+				caStatus.AutoscalerStatus = ""
+				caStatus.ClusterWide.Health.Status = ""
+				nodeGroup.Health.Status = ""
+			},
+		},
+		{
+			name: "stale",
+			modify: func(caStatus *status.ClusterAutoscalerStatus, _ *status.NodeGroupsType) {
+				caStatus.Time = now.Add(-10 * time.Minute)
+			},
+			expected: SkipReasonStaleAutoscalerStatus,
+		},
+		{
+			name: "initializing",
+			modify: func(caStatus *status.ClusterAutoscalerStatus, _ *status.NodeGroupsType) {
+				caStatus.AutoscalerStatus = "Initializing"
+			},
+			expected: SkipReasonAutoscalerUnhealthy,
+		},
+		{
+			name: "cluster unhealthy",
+			modify: func(caStatus *status.ClusterAutoscalerStatus, _ *status.NodeGroupsType) {
+				caStatus.ClusterWide.Health.Status = "Unhealthy"
+			},
+			expected: SkipReasonAutoscalerUnhealthy,
+		},
+		{
+			name: "node group unhealthy",
+			modify: func(_ *status.ClusterAutoscalerStatus, nodeGroup *status.NodeGroupsType) {
+				nodeGroup.Health.Status = "Unhealthy"
+			},
+			expected: SkipReasonNodePoolUnhealthy,
+		},
+		{
+			name: "node group unregistered nodes",
+			modify: func(_ *status.ClusterAutoscalerStatus, nodeGroup *status.NodeGroupsType) {
+				nodeGroup.Health.NodeCounts.LongUnregistered = 1
+			},
+			expected: SkipReasonNodePoolUnhealthy,
+		},
+		{
+			name: "node group backoff",
+			modify: func(_ *status.ClusterAutoscalerStatus, nodeGroup *status.NodeGroupsType) {
+				nodeGroup.ScaleUp.Status = status.ScaleUpStatusBackoff
+			},
+			expected: SkipReasonNodePoolBackoff,
+		},
+		{
+			name: "cluster scale up in progress",
+			modify: func(caStatus *status.ClusterAutoscalerStatus, _ *status.NodeGroupsType) {
+				caStatus.ClusterWide.ScaleUp.Status = status.ScaleUpStatusInProgress
+			},
+			expected: SkipReasonScaleUpInProgress,
+		},
+		{
+			name: "node group scale up in progress",
+			modify: func(_ *status.ClusterAutoscalerStatus, nodeGroup *status.NodeGroupsType) {
+				nodeGroup.ScaleUp.Status = status.ScaleUpStatusInProgress
+			},
+			expected: SkipReasonScaleUpInProgress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caStatus, nodeGroup := healthy()
+			tt.modify(caStatus, nodeGroup)
+			reason, ok := clusterAutoscalerSkipReason(caStatus, nodeGroup, 5*time.Minute, now)
+			require.Equal(t, tt.expected != "", ok)
+			require.Equal(t, tt.expected, reason)
+		})
+	}
+
+	// The max age is not checked when it is zero.
+	caStatus, nodeGroup := healthy()
+	caStatus.Time = now.Add(-24 * time.Hour)
+	_, ok := clusterAutoscalerSkipReason(caStatus, nodeGroup, 0, now)
+	require.False(t, ok)
+}
+
+func TestScaleUpInProgress(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	node := testNodeWithTTL("foo", &creationOffset, 1*time.Minute, false)
+	node.Labels[status.KubemarkNodePoolLabelKey] = "foo"
+	evicting := testNodeWithTTL("bar", &creationOffset, 1*time.Minute, true)
+	evicting.Labels[status.KubemarkNodePoolLabelKey] = "foo"
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-autoscaler-status",
+			Namespace: "cluster-autoscaler",
+		},
+		Data: map[string]string{
+			"status": `clusterWide:
+  scaleUp:
+    status: InProgress
+nodeGroups:
+- name: foo
+  health:
+    status: Healthy
+    nodeCounts:
+      registered:
+        total: 3
+        ready: 3
+    minSize: 1
+    maxSize: 3`,
+		},
+	}
+	clusterAutoscalerStatus := &types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset(node, evicting, configMap)
+	nodeCache, err := newNodeCache(ctx, client, NodeTtlLabelKey, clusterAutoscalerStatus)
+	require.NoError(t, err)
+	eval, err := evaluateNodes(ctx, nodeCache, Options{ClusterAutoscalerStatus: clusterAutoscalerStatus}, nil)
+	require.NoError(t, err)
+	require.Equal(t, SkipReasonScaleUpInProgress, eval.skipped["foo"])
+	// Evictions which have already started are not paused.
+	require.Len(t, eval.candidates, 1)
+	require.Equal(t, "bar", eval.candidates[0].Name)
+}
+
+func TestNextExpiry(t *testing.T) {
+	expiredOffset := -10 * time.Minute
+	firstOffset := -30 * time.Minute
+	secondOffset := -50 * time.Minute
+	nodes := []*corev1.Node{
+		testNodeWithTTL("expired", &expiredOffset, 1*time.Minute, false),
+		testNodeWithTTL("first", &firstOffset, 1*time.Hour, false),
+		testNodeWithTTL("second", &secondOffset, 2*time.Hour, false),
+	}
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset()
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "expired", eval.candidates[0].Name)
+	require.Equal(t, nodes[1].CreationTimestamp.Add(1*time.Hour), eval.nextEvaluation)
+}
+
+func TestNodePoolEvictionLimit(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	testNode := func(name, nodePool string, evicting bool) *corev1.Node {
+		node := testNodeWithTTL(name, &creationOffset, 1*time.Minute, evicting)
+		node.Labels[status.KubemarkNodePoolLabelKey] = nodePool
+		return node
+	}
+	nodes := []*corev1.Node{
+		testNode("foo-1", "foo", true),
+		testNode("foo-2", "foo", false),
+		testNode("bar-1", "bar", false),
+		testNode("bar-2", "bar", false),
+		testNode("baz-1", "baz", false),
+		testNode("baz-2", "baz", false),
+	}
+	nodes[5].Labels[NodePoolMaxConcurrentEvictionsLabelKey] = "2"
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset()
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	opts := Options{
+		MaxConcurrentEvictionsPerNodePool: 1,
+		NodePoolMaxConcurrentEvictions:    map[string]int{"bar": 2},
+	}
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), opts, nil)
+	require.NoError(t, err)
+	names := []string{}
+	for _, node := range eval.candidates {
+		names = append(names, node.Name)
+	}
+	require.ElementsMatch(t, []string{"foo-1", "bar-1", "bar-2", "baz-1", "baz-2"}, names)
+	require.Equal(t, SkipReasonNodePoolEvictionLimit, eval.skipped["foo-2"])
+}
+
+func TestOutsideMaintenanceWindow(t *testing.T) {
+	creationOffset := -10 * time.Minute
+	nodes := []*corev1.Node{
+		testNodeWithTTL("inside", &creationOffset, 1*time.Minute, false),
+		testNodeWithTTL("outside", &creationOffset, 1*time.Minute, false),
+		testNodeWithTTL("evicting", &creationOffset, 1*time.Minute, true),
+	}
+	// Window which only opens in two days, making sure that it is not open now.
+	weekday := time.Now().UTC().Add(48 * time.Hour).Weekday().String()[:3]
+	nodes[1].Annotations = map[string]string{MaintenanceWindowAnnotationKey: weekday + " 00:00-01:00"}
+	nodes[2].Annotations[MaintenanceWindowAnnotationKey] = weekday + " 00:00-01:00"
+
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset()
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	eval, err := evaluateNodes(ctx, testNodeCache(t, client), Options{MaintenanceWindowLocation: time.UTC}, nil)
+	require.NoError(t, err)
+	names := []string{}
+	for _, node := range eval.candidates {
+		names = append(names, node.Name)
+	}
+	require.Equal(t, []string{"evicting", "inside"}, names)
+	require.Equal(t, SkipReasonOutsideMaintenanceWindow, eval.skipped["outside"])
+	require.False(t, eval.nextEvaluation.IsZero())
+}
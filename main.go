@@ -7,30 +7,100 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/alexflint/go-arg"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/xenitab/pkg/kubernetes"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 
+	"github.com/xenitab/node-ttl/internal/config"
+	"github.com/xenitab/node-ttl/internal/maintenance"
+	"github.com/xenitab/node-ttl/internal/status"
 	"github.com/xenitab/node-ttl/internal/ttl"
 )
 
 //nolint:lll //ignore
 type arguments struct {
-	ProbeAddr                string        `arg:"--probe-addr" default:":8080" help:"address to serve probe."`
-	MetricsAddr              string        `arg:"--metrics-addr" default:":9090" help:"address to serve metrics."`
-	KubeConfigPath           string        `arg:"--kubeconfig" help:"path to the kubeconfig file"`
-	Interval                 time.Duration `arg:"--interval" default:"10m" help:"interval at which to evaluate node ttl"`
-	NodePoolMinCheck         bool          `arg:"--min-check" default:"true" help:"check if node pool min size will not allow scale down"`
-	StatusConfigMapName      string        `arg:"--status-config-map-name" default:"cluster-autoscaler-status" help:"Cluster autoscaler status configmap name"`
-	StatusConfigMapNamespace string        `arg:"--status-config-map-namespace" default:"cluster-autoscaler" help:"Cluster autoscaler status configmap namespace"`
+	ProbeAddr                      string         `arg:"--probe-addr" default:":8080" help:"address to serve probe."`
+	MetricsAddr                    string         `arg:"--metrics-addr" default:":9090" help:"address to serve metrics."`
+	KubeConfigPath                 string         `arg:"--kubeconfig" help:"path to the kubeconfig file"`
+	ConfigPath                     string         `arg:"--config" help:"path to the config file"`
+	NodeTTLPolicies                bool           `arg:"--node-ttl-policies" default:"false" help:"read node ttl settings from NodeTTLPolicy custom resources"`
+	Interval                       time.Duration  `arg:"--interval" default:"10m" help:"resync period at which nodes are evaluated even if no changes have been observed"`
+	NodePoolMinCheck               bool           `arg:"--min-check" default:"true" help:"check if node pool min size will not allow scale down"`
+	NodePoolResolver               string         `arg:"--node-pool-resolver" default:"Default" help:"how node pools are identified, either Default for AKS, EKS and kubemark, GKE, ClusterAPI, Karpenter or Label"`
+	NodePoolLabel                  string         `arg:"--node-pool-label" help:"label identifying the node pool of nodes when using the Label node pool resolver"`
+	NodePoolNameTemplate           string         `arg:"--node-pool-name-template" help:"template of the node pool name in the cluster autoscaler status when using the Label node pool resolver, defaults to the label value"`
+	NodePoolHeadroom               int            `arg:"--node-pool-headroom" default:"0" help:"number of ready nodes above the min size a node pool has to keep when a new eviction starts"`
+	ResourceCheck                  bool           `arg:"--resource-check" default:"false" help:"only evict a node when the resources requested by its pods are free on the other nodes in the node pool"`
+	StatusMaxAge                   time.Duration  `arg:"--status-max-age" default:"5m" help:"how old the cluster autoscaler status may be before new evictions are paused, zero disables the check"`
+	StatusConfigMapName            string         `arg:"--status-config-map-name" default:"cluster-autoscaler-status" help:"Cluster autoscaler status configmap name"`
+	StatusConfigMapNamespace       string         `arg:"--status-config-map-namespace" default:"cluster-autoscaler" help:"Cluster autoscaler status configmap namespace"`
+	MaxConcurrentEvictions         string         `arg:"--max-concurrent-evictions" default:"1" help:"maximum number of nodes evicted at the same time, either an absolute number or a percentage of nodes with a ttl"`
+	MaxConcurrentPoolEvictions     int            `arg:"--max-concurrent-evictions-per-node-pool" default:"1" help:"maximum number of nodes evicted at the same time in a single node pool, zero means no limit"`
+	NodePoolMaxConcurrentEvictions map[string]int `arg:"--node-pool-max-concurrent-evictions" help:"override max concurrent evictions for specific node pools as pool=value"`
+	MaintenanceWindows             []string       `arg:"--maintenance-window,separate" help:"window during which evictions may start, either weekday and time range like 'Mon-Fri 22:00-06:00' or cron and duration like '0 22 * * 1-5 8h'"`
+	MaintenanceWindowTimeZone      string         `arg:"--maintenance-window-time-zone" default:"UTC" help:"default time zone of maintenance windows"`
+	DrainTimeout                   time.Duration  `arg:"--drain-timeout" default:"0s" help:"how long a drain may take before the drain timeout policy is applied, zero means no timeout"`
+	DrainTimeoutPolicy             string         `arg:"--drain-timeout-policy" default:"Uncordon" help:"what to do when the drain timeout is reached, either Uncordon or DeletePods"`
+	DrainBackoff                   time.Duration  `arg:"--drain-backoff" default:"6h" help:"how long to wait before evicting a node again after it has been uncordoned due to a drain timeout"`
+	DrainForce                     bool           `arg:"--drain-force" default:"true" help:"delete pods which are not managed by a controller"`
+	DrainGracePeriod               int            `arg:"--drain-grace-period" default:"-1" help:"seconds given to each pod to terminate gracefully, a negative value uses the grace period of the pod"`
+	DrainDeleteEmptyDirData        bool           `arg:"--drain-delete-emptydir-data" default:"true" help:"delete pods using emptyDir volumes, deleting the data in the volumes"`
+	DrainPodSelector               string         `arg:"--drain-pod-selector" help:"label selector limiting which pods are drained"`
+	DrainSkipWaitForDeleteTimeout  int            `arg:"--drain-skip-wait-for-delete-timeout" default:"0" help:"skip waiting for pods which have been deleted for longer than this many seconds, zero always waits"`
+	DrainDisableEviction           bool           `arg:"--drain-disable-eviction" default:"false" help:"delete pods instead of evicting them, bypassing pod disruption budgets"`
+	PostDrainAction                string         `arg:"--post-drain-action" default:"None" help:"what to do with a node after it has been drained, either None to leave it to cluster autoscaler or DeleteNode"`
+	RemovalTimeout                 time.Duration  `arg:"--removal-timeout" default:"1h" help:"how long a drained node may remain before it is reported as not removed, zero disables the check"`
+	Surge                          bool           `arg:"--surge" default:"false" help:"add a new node to node pools at their min count before draining an expired node instead of skipping it"`
+	SurgeNamespace                 string         `arg:"--surge-namespace,env:POD_NAMESPACE" help:"namespace of the placeholder pods used to add new nodes"`
+	SurgeImage                     string         `arg:"--surge-image" default:"registry.k8s.io/pause:3.10" help:"image of the placeholder pods used to add new nodes"`
+	SurgeTimeout                   time.Duration  `arg:"--surge-timeout" default:"15m" help:"how long to wait for a new node to become ready before giving up the eviction"`
+	DryRun                         bool           `arg:"--dry-run" default:"false" help:"only report nodes that would be evicted without cordoning or draining them"`
+	ReadinessFailureThreshold      int64          `arg:"--readiness-failure-threshold" default:"3" help:"consecutive failed evaluations after which the readiness probe fails, zero disables the check"`
+	LivenessFailureThreshold       int64          `arg:"--liveness-failure-threshold" default:"10" help:"consecutive failed evaluations after which the liveness probe fails, zero disables the check"`
+	LeaderElection                 bool           `arg:"--leader-election" default:"false" help:"enable leader election to allow running multiple replicas"`
+	LeaderElectionName             string         `arg:"--leader-election-name" default:"node-ttl" help:"name of the lease used for leader election"`
+	LeaderElectionNamespace        string         `arg:"--leader-election-namespace,env:POD_NAMESPACE" help:"namespace of the lease used for leader election"`
+	LeaseDuration                  time.Duration  `arg:"--leader-election-lease-duration" default:"15s" help:"duration that non-leader candidates will wait to force acquire leadership"`
+	RenewDeadline                  time.Duration  `arg:"--leader-election-renew-deadline" default:"10s" help:"duration that the acting leader will retry refreshing leadership before giving up"`
+	RetryPeriod                    time.Duration  `arg:"--leader-election-retry-period" default:"2s" help:"duration between leader election acquire and renew attempts"`
+}
+
+var isLeader = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "node_ttl_is_leader",
+	Help: "Set to 1 if this replica is the current leader, 0 otherwise.",
+})
+
+// leading is true while this replica is the leader, it is served by the leader readiness check.
+var leading atomic.Bool
+
+// setLeader records whether this replica is the current leader.
+func setLeader(leader bool) {
+	leading.Store(leader)
+	if leader {
+		isLeader.Set(1)
+		return
+	}
+	isLeader.Set(0)
 }
 
 func main() {
@@ -57,25 +127,124 @@ func run(log logr.Logger, args *arguments) error {
 		return err
 	}
 
+	var policyClient dynamic.Interface
+	if args.NodeTTLPolicies {
+		cfg, err := restConfig(args.KubeConfigPath)
+		if err != nil {
+			return err
+		}
+		policyClient, err = dynamic.NewForConfig(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 	g, ctx := errgroup.WithContext(ctx)
 	ctx = logr.NewContext(ctx, log)
 
+	location, err := time.LoadLocation(args.MaintenanceWindowTimeZone)
+	if err != nil {
+		return err
+	}
+	maintenanceWindows := []*maintenance.Window{}
+	for _, spec := range args.MaintenanceWindows {
+		window, err := maintenance.Parse(spec, location)
+		if err != nil {
+			return err
+		}
+		maintenanceWindows = append(maintenanceWindows, window)
+	}
+
+	cfg := &config.Config{}
+	if args.ConfigPath != "" {
+		cfg, err = config.Load(args.ConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+	ttlRules, err := cfg.ParseTTLRules()
+	if err != nil {
+		return err
+	}
+	err = applyDrainConfig(args, cfg.Drain, explicitFlags(os.Args[1:]))
+	if err != nil {
+		return err
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	terminator, err := newNodeTerminator(args.PostDrainAction, clientset)
+	if err != nil {
+		return err
+	}
+	nodePoolResolver, err := status.NewNodePoolResolver(args.NodePoolResolver, status.NodePoolResolverOptions{
+		LabelKey:     args.NodePoolLabel,
+		NameTemplate: args.NodePoolNameTemplate,
+	})
+	if err != nil {
+		return err
+	}
+
+	health := &ttl.Health{}
+	report := &ttl.Report{}
 	g.Go(func() error {
 		var nn *types.NamespacedName
 		if args.NodePoolMinCheck {
 			nn = &types.NamespacedName{Namespace: args.StatusConfigMapNamespace, Name: args.StatusConfigMapName}
 		}
-		err := ttl.Run(ctx, clientset, args.Interval, nn)
-		if err != nil {
+		opts := ttl.Options{
+			Interval:                             args.Interval,
+			ClusterAutoscalerStatus:              nn,
+			ClusterAutoscalerStatusMaxAge:        args.StatusMaxAge,
+			NodePoolHeadroom:                     args.NodePoolHeadroom,
+			ResourceCheck:                        args.ResourceCheck,
+			DryRun:                               args.DryRun,
+			MaxConcurrentEvictions:               intstr.Parse(args.MaxConcurrentEvictions),
+			MaxConcurrentEvictionsPerNodePool:    args.MaxConcurrentPoolEvictions,
+			NodePoolMaxConcurrentEvictions:       args.NodePoolMaxConcurrentEvictions,
+			MaintenanceWindows:                   maintenanceWindows,
+			MaintenanceWindowLocation:            location,
+			PolicyClient:                         policyClient,
+			TTLRules:                             ttlRules,
+			DrainTimeout:                         args.DrainTimeout,
+			DrainTimeoutPolicy:                   ttl.DrainTimeoutPolicy(args.DrainTimeoutPolicy),
+			DrainBackoff:                         args.DrainBackoff,
+			DrainForce:                           args.DrainForce,
+			DrainGracePeriodSeconds:              args.DrainGracePeriod,
+			DrainDeleteEmptyDirData:              args.DrainDeleteEmptyDirData,
+			DrainPodSelector:                     args.DrainPodSelector,
+			DrainSkipWaitForDeleteTimeoutSeconds: args.DrainSkipWaitForDeleteTimeout,
+			DrainDisableEviction:                 args.DrainDisableEviction,
+			Surge:                                args.Surge,
+			SurgeNamespace:                       args.SurgeNamespace,
+			SurgeImage:                           args.SurgeImage,
+			SurgeTimeout:                         args.SurgeTimeout,
+			Terminator:                           terminator,
+			RemovalTimeout:                       args.RemovalTimeout,
+			NodePoolResolver:                     nodePoolResolver,
+			Identity:                             identity,
+		}
+		if err := opts.Validate(); err != nil {
 			return err
 		}
-		return nil
+		runTTL := func(ctx context.Context) error {
+			return ttl.Run(ctx, clientset, opts, health, report)
+		}
+		if !args.LeaderElection {
+			setLeader(true)
+			return runTTL(ctx)
+		}
+		return runLeaderElection(ctx, clientset, args, identity, runTTL)
 	})
 
 	metricsMux := http.NewServeMux()
 	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsMux.Handle("/api/v1/", report.Handler())
 	metricsSrv := &http.Server{
 		Addr:              args.MetricsAddr,
 		ReadHeaderTimeout: 10 * time.Second,
@@ -96,6 +265,25 @@ func run(log logr.Logger, args *arguments) error {
 
 	probeMux := http.NewServeMux()
 	probeMux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if exceedsThreshold(health.ConsecutiveFailures(), args.ReadinessFailureThreshold) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	// Leadership is a separate check so that replicas waiting for the Lease are still ready during rolling updates.
+	probeMux.HandleFunc("/readyz/leader", func(w http.ResponseWriter, req *http.Request) {
+		if !leading.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	probeMux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		if exceedsThreshold(health.ConsecutiveFailures(), args.LivenessFailureThreshold) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 	})
 	probeSrv := http.Server{
@@ -123,3 +311,142 @@ func run(log logr.Logger, args *arguments) error {
 	}
 	return nil
 }
+
+// applyDrainConfig sets the drain arguments to the drain options in the config file. Flags which were set explicitly
+// take precedence over the config file, which takes precedence over the flag defaults.
+func applyDrainConfig(args *arguments, spec config.DrainSpec, explicit map[string]struct{}) error {
+	configured := func(flag string) bool {
+		_, ok := explicit[flag]
+		return !ok
+	}
+	timeout, err := spec.ParseTimeout()
+	if err != nil {
+		return err
+	}
+	if timeout != nil && configured("drain-timeout") {
+		args.DrainTimeout = *timeout
+	}
+	if spec.Force != nil && configured("drain-force") {
+		args.DrainForce = *spec.Force
+	}
+	if spec.GracePeriodSeconds != nil && configured("drain-grace-period") {
+		args.DrainGracePeriod = *spec.GracePeriodSeconds
+	}
+	if spec.DeleteEmptyDirData != nil && configured("drain-delete-emptydir-data") {
+		args.DrainDeleteEmptyDirData = *spec.DeleteEmptyDirData
+	}
+	if spec.PodSelector != nil && configured("drain-pod-selector") {
+		args.DrainPodSelector = *spec.PodSelector
+	}
+	if spec.SkipWaitForDeleteTimeoutSeconds != nil && configured("drain-skip-wait-for-delete-timeout") {
+		args.DrainSkipWaitForDeleteTimeout = *spec.SkipWaitForDeleteTimeoutSeconds
+	}
+	if spec.DisableEviction != nil && configured("drain-disable-eviction") {
+		args.DrainDisableEviction = *spec.DisableEviction
+	}
+	return nil
+}
+
+// explicitFlags returns the names of the long flags set on the command line, like "drain-force".
+func explicitFlags(argv []string) map[string]struct{} {
+	explicit := map[string]struct{}{}
+	for _, a := range argv {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "--") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(a, "--"), "=")
+		explicit[name] = struct{}{}
+	}
+	return explicit
+}
+
+// newNodeTerminator returns the terminator for the post drain action, nil is returned when drained nodes should be
+// left to cluster autoscaler.
+func newNodeTerminator(action string, clientset k8s.Interface) (ttl.NodeTerminator, error) {
+	switch action {
+	case "None":
+		return nil, nil
+	case "DeleteNode":
+		return ttl.NewDeleteNodeTerminator(clientset), nil
+	default:
+		return nil, fmt.Errorf("invalid post drain action: %s", action)
+	}
+}
+
+// restConfig returns the config from the kubeconfig file at the path, or the in cluster config if the path is empty.
+func restConfig(path string) (*rest.Config, error) {
+	if path != "" {
+		return clientcmd.BuildConfigFromFlags("", path)
+	}
+	return rest.InClusterConfig()
+}
+
+// exceedsThreshold returns true if the number of consecutive failures has reached the threshold, zero meaning no threshold.
+func exceedsThreshold(failures, threshold int64) bool {
+	return threshold > 0 && failures >= threshold
+}
+
+// runLeaderElection blocks until the context is cancelled, only calling fn while holding the leader lease.
+// Losing the lease is returned as an error so that the process restarts and rejoins the election.
+func runLeaderElection(ctx context.Context, clientset k8s.Interface, args *arguments, identity string,
+	fn func(context.Context) error) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	if args.LeaderElectionNamespace == "" {
+		return errors.New("leader election namespace has to be set when leader election is enabled")
+	}
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		args.LeaderElectionNamespace,
+		args.LeaderElectionName,
+		clientset.CoreV1(),
+		clientset.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity},
+	)
+	if err != nil {
+		return err
+	}
+
+	leCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errCh := make(chan error, 1)
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            args.LeaderElectionName,
+		LeaseDuration:   args.LeaseDuration,
+		RenewDeadline:   args.RenewDeadline,
+		RetryPeriod:     args.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Info("acquired leadership", "identity", identity)
+				setLeader(true)
+				if err := fn(ctx); err != nil {
+					errCh <- err
+				}
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				setLeader(false)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("waiting for leadership", "identity", identity, "lease", args.LeaderElectionName)
+	le.Run(leCtx)
+	select {
+	case err := <-errCh:
+		return err
+	default:
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return errors.New("lost leadership")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xenitab/node-ttl/internal/config"
+)
+
+func TestApplyDrainConfigPrecedence(t *testing.T) {
+	argv := []string{"--drain-force=false", "--drain-grace-period", "30", "--", "--drain-timeout=1h"}
+	args := &arguments{}
+	parser, err := arg.NewParser(arg.Config{}, args)
+	require.NoError(t, err)
+	err = parser.Parse(argv[:3])
+	require.NoError(t, err)
+
+	force := true
+	gracePeriod := 60
+	deleteEmptyDirData := false
+	timeout := "2h"
+	spec := config.DrainSpec{
+		Force:              &force,
+		GracePeriodSeconds: &gracePeriod,
+		DeleteEmptyDirData: &deleteEmptyDirData,
+		Timeout:            &timeout,
+	}
+	err = applyDrainConfig(args, spec, explicitFlags(argv))
+	require.NoError(t, err)
+	// Explicit flags take precedence over the config file.
+	require.False(t, args.DrainForce)
+	require.Equal(t, 30, args.DrainGracePeriod)
+	// The config file takes precedence over flag defaults, arguments after "--" are not flags.
+	require.False(t, args.DrainDeleteEmptyDirData)
+	require.Equal(t, 2*time.Hour, args.DrainTimeout)
+	// Options which are not set anywhere keep the flag default.
+	require.Equal(t, 0, args.DrainSkipWaitForDeleteTimeout)
+}